@@ -3,7 +3,10 @@ module github.com/stakater/Reloader
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/argoproj/argo-rollouts v1.0.2
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/google/cel-go v0.9.0
 	github.com/onsi/ginkgo v1.15.1 // indirect
 	github.com/onsi/gomega v1.11.0 // indirect
 	github.com/openshift/api v0.0.0-20210527122704-efd9d5958e01
@@ -11,9 +14,11 @@ require (
 	github.com/prometheus/client_golang v1.10.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/cobra v1.1.3
+	google.golang.org/genproto v0.0.0-20210831024726-fe130286e0e2
 	k8s.io/api v0.21.2
 	k8s.io/apimachinery v0.21.2
 	k8s.io/client-go v0.21.2
+	sigs.k8s.io/yaml v1.2.0
 )
 
 // Replacements for argo-rollouts
@@ -46,4 +51,4 @@ replace (
 	k8s.io/sample-apiserver => k8s.io/sample-apiserver v0.20.4
 	k8s.io/sample-cli-plugin => k8s.io/sample-cli-plugin v0.20.4
 	k8s.io/sample-controller => k8s.io/sample-controller v0.20.4
-)
\ No newline at end of file
+)
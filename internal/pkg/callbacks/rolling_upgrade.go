@@ -2,17 +2,25 @@ package callbacks
 
 import (
 	"context"
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/sirupsen/logrus"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 
 	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	openshiftv1 "github.com/openshift/api/apps/v1"
 )
 
+// fieldManager is the name of the manager owning the fields changed by Reloader
+const fieldManager = "Reloader"
+
 //ItemsFunc is a generic function to return a specific resource array in given namespace
 type ItemsFunc func(kube.Clients, string) []interface{}
 
@@ -25,18 +33,22 @@ type InitContainersFunc func(interface{}) []v1.Container
 //VolumesFunc is a generic func to return volumes
 type VolumesFunc func(interface{}) []v1.Volume
 
-//UpdateFunc performs the resource update
-type UpdateFunc func(kube.Clients, string, interface{}) error
+//ItemFunc is a generic function to return a specific resource by name in given namespace
+type ItemFunc func(kube.Clients, string, string) (interface{}, error)
+
+//UpdateFunc performs the resource update by patching the changes from the original to the modified resource
+type UpdateFunc func(kube.Clients, string, interface{}, interface{}) error
 
 //AnnotationsFunc is a generic func to return annotations
 type AnnotationsFunc func(interface{}) map[string]string
 
-//PodAnnotationsFunc is a generic func to return annotations
+//PodAnnotationsFunc is a generic func to return annotations, the returned map can be modified in place
 type PodAnnotationsFunc func(interface{}) map[string]string
 
 //RollingUpgradeFuncs contains generic functions to perform rolling upgrade
 type RollingUpgradeFuncs struct {
 	ItemsFunc          ItemsFunc
+	ItemFunc           ItemFunc
 	AnnotationsFunc    AnnotationsFunc
 	PodAnnotationsFunc PodAnnotationsFunc
 	ContainersFunc     ContainersFunc
@@ -91,165 +103,281 @@ func GetRolloutItems(clients kube.Clients, namespace string) []interface{} {
 	return util.InterfaceSlice(rollouts.Items)
 }
 
+// GetDeploymentItem returns the deployment with given name in given namespace
+func GetDeploymentItem(clients kube.Clients, name string, namespace string) (interface{}, error) {
+	return clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+}
+
+// GetDaemonSetItem returns the daemonSet with given name in given namespace
+func GetDaemonSetItem(clients kube.Clients, name string, namespace string) (interface{}, error) {
+	return clients.KubernetesClient.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+}
+
+// GetStatefulSetItem returns the statefulSet with given name in given namespace
+func GetStatefulSetItem(clients kube.Clients, name string, namespace string) (interface{}, error) {
+	return clients.KubernetesClient.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+}
+
+// GetDeploymentConfigItem returns the deploymentConfig with given name in given namespace
+func GetDeploymentConfigItem(clients kube.Clients, name string, namespace string) (interface{}, error) {
+	return clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+}
+
+// GetRolloutItem returns the rollout with given name in given namespace
+func GetRolloutItem(clients kube.Clients, name string, namespace string) (interface{}, error) {
+	return clients.ArgoRolloutClient.ArgoprojV1alpha1().Rollouts(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+}
+
 // GetDeploymentAnnotations returns the annotations of given deployment
 func GetDeploymentAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.Deployment).ObjectMeta.Annotations
+	return item.(*appsv1.Deployment).ObjectMeta.Annotations
 }
 
 // GetDaemonSetAnnotations returns the annotations of given daemonSet
 func GetDaemonSetAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.DaemonSet).ObjectMeta.Annotations
+	return item.(*appsv1.DaemonSet).ObjectMeta.Annotations
 }
 
 // GetStatefulSetAnnotations returns the annotations of given statefulSet
 func GetStatefulSetAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.StatefulSet).ObjectMeta.Annotations
+	return item.(*appsv1.StatefulSet).ObjectMeta.Annotations
 }
 
 // GetDeploymentConfigAnnotations returns the annotations of given deploymentConfig
 func GetDeploymentConfigAnnotations(item interface{}) map[string]string {
-	return item.(openshiftv1.DeploymentConfig).ObjectMeta.Annotations
+	return item.(*openshiftv1.DeploymentConfig).ObjectMeta.Annotations
 }
 
 // GetRolloutAnnotations returns the annotations of given rollout
 func GetRolloutAnnotations(item interface{}) map[string]string {
-	return item.(argorolloutv1alpha1.Rollout).ObjectMeta.Annotations
+	return item.(*argorolloutv1alpha1.Rollout).ObjectMeta.Annotations
 }
 
 // GetDeploymentPodAnnotations returns the pod's annotations of given deployment
 func GetDeploymentPodAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.Deployment).Spec.Template.ObjectMeta.Annotations
+	deployment := item.(*appsv1.Deployment)
+	if deployment.Spec.Template.ObjectMeta.Annotations == nil {
+		deployment.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return deployment.Spec.Template.ObjectMeta.Annotations
 }
 
 // GetDaemonSetPodAnnotations returns the pod's annotations of given daemonSet
 func GetDaemonSetPodAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.DaemonSet).Spec.Template.ObjectMeta.Annotations
+	daemonSet := item.(*appsv1.DaemonSet)
+	if daemonSet.Spec.Template.ObjectMeta.Annotations == nil {
+		daemonSet.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return daemonSet.Spec.Template.ObjectMeta.Annotations
 }
 
 // GetStatefulSetPodAnnotations returns the pod's annotations of given statefulSet
 func GetStatefulSetPodAnnotations(item interface{}) map[string]string {
-	return item.(appsv1.StatefulSet).Spec.Template.ObjectMeta.Annotations
+	statefulSet := item.(*appsv1.StatefulSet)
+	if statefulSet.Spec.Template.ObjectMeta.Annotations == nil {
+		statefulSet.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return statefulSet.Spec.Template.ObjectMeta.Annotations
 }
 
 // GetDeploymentConfigPodAnnotations returns the pod's annotations of given deploymentConfig
 func GetDeploymentConfigPodAnnotations(item interface{}) map[string]string {
-	return item.(openshiftv1.DeploymentConfig).Spec.Template.ObjectMeta.Annotations
+	deploymentConfig := item.(*openshiftv1.DeploymentConfig)
+	if deploymentConfig.Spec.Template.ObjectMeta.Annotations == nil {
+		deploymentConfig.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return deploymentConfig.Spec.Template.ObjectMeta.Annotations
 }
 
 // GetRolloutPodAnnotations returns the pod's annotations of given rollout
 func GetRolloutPodAnnotations(item interface{}) map[string]string {
-	return item.(argorolloutv1alpha1.Rollout).Spec.Template.ObjectMeta.Annotations
+	rollout := item.(*argorolloutv1alpha1.Rollout)
+	if rollout.Spec.Template.ObjectMeta.Annotations == nil {
+		rollout.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return rollout.Spec.Template.ObjectMeta.Annotations
 }
 
 // GetDeploymentContainers returns the containers of given deployment
 func GetDeploymentContainers(item interface{}) []v1.Container {
-	return item.(appsv1.Deployment).Spec.Template.Spec.Containers
+	return item.(*appsv1.Deployment).Spec.Template.Spec.Containers
 }
 
 // GetDaemonSetContainers returns the containers of given daemonSet
 func GetDaemonSetContainers(item interface{}) []v1.Container {
-	return item.(appsv1.DaemonSet).Spec.Template.Spec.Containers
+	return item.(*appsv1.DaemonSet).Spec.Template.Spec.Containers
 }
 
 // GetStatefulSetContainers returns the containers of given statefulSet
 func GetStatefulSetContainers(item interface{}) []v1.Container {
-	return item.(appsv1.StatefulSet).Spec.Template.Spec.Containers
+	return item.(*appsv1.StatefulSet).Spec.Template.Spec.Containers
 }
 
 // GetDeploymentConfigContainers returns the containers of given deploymentConfig
 func GetDeploymentConfigContainers(item interface{}) []v1.Container {
-	return item.(openshiftv1.DeploymentConfig).Spec.Template.Spec.Containers
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.Containers
 }
 
 // GetRolloutContainers returns the containers of given rollout
 func GetRolloutContainers(item interface{}) []v1.Container {
-	return item.(argorolloutv1alpha1.Rollout).Spec.Template.Spec.Containers
+	return item.(*argorolloutv1alpha1.Rollout).Spec.Template.Spec.Containers
 }
 
 // GetDeploymentInitContainers returns the containers of given deployment
 func GetDeploymentInitContainers(item interface{}) []v1.Container {
-	return item.(appsv1.Deployment).Spec.Template.Spec.InitContainers
+	return item.(*appsv1.Deployment).Spec.Template.Spec.InitContainers
 }
 
 // GetDaemonSetInitContainers returns the containers of given daemonSet
 func GetDaemonSetInitContainers(item interface{}) []v1.Container {
-	return item.(appsv1.DaemonSet).Spec.Template.Spec.InitContainers
+	return item.(*appsv1.DaemonSet).Spec.Template.Spec.InitContainers
 }
 
 // GetStatefulSetInitContainers returns the containers of given statefulSet
 func GetStatefulSetInitContainers(item interface{}) []v1.Container {
-	return item.(appsv1.StatefulSet).Spec.Template.Spec.InitContainers
+	return item.(*appsv1.StatefulSet).Spec.Template.Spec.InitContainers
 }
 
 // GetDeploymentConfigInitContainers returns the containers of given deploymentConfig
 func GetDeploymentConfigInitContainers(item interface{}) []v1.Container {
-	return item.(openshiftv1.DeploymentConfig).Spec.Template.Spec.InitContainers
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.InitContainers
 }
 
 // GetRolloutInitContainers returns the containers of given rollout
 func GetRolloutInitContainers(item interface{}) []v1.Container {
-	return item.(argorolloutv1alpha1.Rollout).Spec.Template.Spec.InitContainers
+	return item.(*argorolloutv1alpha1.Rollout).Spec.Template.Spec.InitContainers
 }
 
 // UpdateDeployment performs rolling upgrade on deployment
-func UpdateDeployment(clients kube.Clients, namespace string, resource interface{}) error {
-	deployment := resource.(appsv1.Deployment)
-	_, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Update(context.TODO(), &deployment, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+func UpdateDeployment(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+	deployment := modified.(*appsv1.Deployment)
+	patch, err := createStrategicMergePatch(original, modified, appsv1.Deployment{}, deployment.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = clients.KubernetesClient.AppsV1().Deployments(namespace).Patch(context.TODO(), deployment.Name, types.StrategicMergePatchType, patch, meta_v1.PatchOptions{FieldManager: fieldManager})
 	return err
 }
 
 // UpdateDaemonSet performs rolling upgrade on daemonSet
-func UpdateDaemonSet(clients kube.Clients, namespace string, resource interface{}) error {
-	daemonSet := resource.(appsv1.DaemonSet)
-	_, err := clients.KubernetesClient.AppsV1().DaemonSets(namespace).Update(context.TODO(), &daemonSet, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+func UpdateDaemonSet(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+	daemonSet := modified.(*appsv1.DaemonSet)
+	patch, err := createStrategicMergePatch(original, modified, appsv1.DaemonSet{}, daemonSet.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = clients.KubernetesClient.AppsV1().DaemonSets(namespace).Patch(context.TODO(), daemonSet.Name, types.StrategicMergePatchType, patch, meta_v1.PatchOptions{FieldManager: fieldManager})
 	return err
 }
 
 // UpdateStatefulSet performs rolling upgrade on statefulSet
-func UpdateStatefulSet(clients kube.Clients, namespace string, resource interface{}) error {
-	statefulSet := resource.(appsv1.StatefulSet)
-	_, err := clients.KubernetesClient.AppsV1().StatefulSets(namespace).Update(context.TODO(), &statefulSet, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+func UpdateStatefulSet(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+	statefulSet := modified.(*appsv1.StatefulSet)
+	patch, err := createStrategicMergePatch(original, modified, appsv1.StatefulSet{}, statefulSet.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = clients.KubernetesClient.AppsV1().StatefulSets(namespace).Patch(context.TODO(), statefulSet.Name, types.StrategicMergePatchType, patch, meta_v1.PatchOptions{FieldManager: fieldManager})
 	return err
 }
 
 // UpdateDeploymentConfig performs rolling upgrade on deploymentConfig
-func UpdateDeploymentConfig(clients kube.Clients, namespace string, resource interface{}) error {
-	deploymentConfig := resource.(openshiftv1.DeploymentConfig)
-	_, err := clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Update(context.TODO(), &deploymentConfig, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+func UpdateDeploymentConfig(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+	deploymentConfig := modified.(*openshiftv1.DeploymentConfig)
+	patch, err := createStrategicMergePatch(original, modified, openshiftv1.DeploymentConfig{}, deploymentConfig.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Patch(context.TODO(), deploymentConfig.Name, types.StrategicMergePatchType, patch, meta_v1.PatchOptions{FieldManager: fieldManager})
 	return err
 }
 
-// UpdateRollout performs rolling upgrade on rollout
-func UpdateRollout(clients kube.Clients, namespace string, resource interface{}) error {
-	rollout := resource.(argorolloutv1alpha1.Rollout)
-	rolloutBefore, _ := clients.ArgoRolloutClient.ArgoprojV1alpha1().Rollouts(namespace).Get(context.TODO(), rollout.Name, meta_v1.GetOptions{})
-	logrus.Warnf("Before: %+v", rolloutBefore.Spec.Template.Spec.Containers[0].Env)
-	logrus.Warnf("After: %+v", rollout.Spec.Template.Spec.Containers[0].Env)
-	_, err := clients.ArgoRolloutClient.ArgoprojV1alpha1().Rollouts(namespace).Update(context.TODO(), &rollout, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+// UpdateRollout performs rolling upgrade on rollout. Rollouts are custom resources which do not support strategic
+// merge patches, so a merge patch guarded by the resourceVersion of the original rollout is used instead
+func UpdateRollout(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+	rollout := modified.(*argorolloutv1alpha1.Rollout)
+	patch, err := createMergePatchWithResourceVersion(original, modified, rollout.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = clients.ArgoRolloutClient.ArgoprojV1alpha1().Rollouts(namespace).Patch(context.TODO(), rollout.Name, types.MergePatchType, patch, meta_v1.PatchOptions{FieldManager: fieldManager})
 	return err
 }
 
+// createStrategicMergePatch creates a patch holding only the changes from original to modified. Lists like
+// containers and env vars are merged by name, so the patch never overwrites fields changed concurrently. Annotations
+// like the reloaded hashes are replaced as a whole though, so the patch fails with a conflict if the resource has been
+// changed since it has been read
+func createStrategicMergePatch(original interface{}, modified interface{}, dataStruct interface{}, resourceVersion string) ([]byte, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	patchJSON, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, dataStruct)
+	if err != nil {
+		return nil, err
+	}
+	return setResourceVersion(patchJSON, resourceVersion)
+}
+
+// createMergePatchWithResourceVersion creates a merge patch from original to modified which fails with a conflict
+// if the resource has been changed since it has been read
+func createMergePatchWithResourceVersion(original interface{}, modified interface{}, resourceVersion string) ([]byte, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	patchJSON, err := jsonpatch.CreateMergePatch(originalJSON, modifiedJSON)
+	if err != nil {
+		return nil, err
+	}
+	return setResourceVersion(patchJSON, resourceVersion)
+}
+
+// setResourceVersion adds the resourceVersion to the metadata of the patch as a precondition
+func setResourceVersion(patchJSON []byte, resourceVersion string) ([]byte, error) {
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(patchJSON, &patch); err != nil {
+		return nil, err
+	}
+	metadata, ok := patch["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		patch["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+	return json.Marshal(patch)
+}
+
 // GetDeploymentVolumes returns the Volumes of given deployment
 func GetDeploymentVolumes(item interface{}) []v1.Volume {
-	return item.(appsv1.Deployment).Spec.Template.Spec.Volumes
+	return item.(*appsv1.Deployment).Spec.Template.Spec.Volumes
 }
 
 // GetDaemonSetVolumes returns the Volumes of given daemonSet
 func GetDaemonSetVolumes(item interface{}) []v1.Volume {
-	return item.(appsv1.DaemonSet).Spec.Template.Spec.Volumes
+	return item.(*appsv1.DaemonSet).Spec.Template.Spec.Volumes
 }
 
 // GetStatefulSetVolumes returns the Volumes of given statefulSet
 func GetStatefulSetVolumes(item interface{}) []v1.Volume {
-	return item.(appsv1.StatefulSet).Spec.Template.Spec.Volumes
+	return item.(*appsv1.StatefulSet).Spec.Template.Spec.Volumes
 }
 
 // GetDeploymentConfigVolumes returns the Volumes of given deploymentConfig
 func GetDeploymentConfigVolumes(item interface{}) []v1.Volume {
-	return item.(openshiftv1.DeploymentConfig).Spec.Template.Spec.Volumes
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.Volumes
 }
 
 // GetRolloutVolumes returns the Volumes of given rollout
 func GetRolloutVolumes(item interface{}) []v1.Volume {
-	return item.(argorolloutv1alpha1.Rollout).Spec.Template.Spec.Volumes
+	return item.(*argorolloutv1alpha1.Rollout).Spec.Template.Spec.Volumes
 }
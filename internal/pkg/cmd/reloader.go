@@ -1,18 +1,26 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stakater/Reloader/internal/pkg/constants"
 	"github.com/stakater/Reloader/internal/pkg/controller"
+	"github.com/stakater/Reloader/internal/pkg/events"
+	"github.com/stakater/Reloader/internal/pkg/leadership"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/selector"
 	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/internal/pkg/workload"
 	"github.com/stakater/Reloader/pkg/kube"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // NewReloaderCommand starts the reloader controller
@@ -26,13 +34,50 @@ func NewReloaderCommand() *cobra.Command {
 	// options
 	cmd.PersistentFlags().StringVar(&options.ConfigmapUpdateOnChangeAnnotation, "configmap-annotation", "configmap.reloader.stakater.com/reload", "annotation to detect changes in configmaps, specified by name")
 	cmd.PersistentFlags().StringVar(&options.SecretUpdateOnChangeAnnotation, "secret-annotation", "secret.reloader.stakater.com/reload", "annotation to detect changes in secrets, specified by name")
+	cmd.PersistentFlags().StringVar(&options.ConfigmapReloadSelectorAnnotation, "configmap-selector-annotation", "configmap.reloader.stakater.com/reload-selector", "annotation to detect changes in configmaps, specified by label selector")
+	cmd.PersistentFlags().StringVar(&options.SecretReloadSelectorAnnotation, "secret-selector-annotation", "secret.reloader.stakater.com/reload-selector", "annotation to detect changes in secrets, specified by label selector")
+	cmd.PersistentFlags().StringVar(&options.ConfigmapExcludeReloaderAnnotation, "configmap-exclude-annotation", "configmaps.exclude.reloader.stakater.com/reload", "annotation to exclude configmaps, specified by name, from the automatic reload")
+	cmd.PersistentFlags().StringVar(&options.SecretExcludeReloaderAnnotation, "secret-exclude-annotation", "secrets.exclude.reloader.stakater.com/reload", "annotation to exclude secrets, specified by name, from the automatic reload")
 	cmd.PersistentFlags().StringVar(&options.ReloaderAutoAnnotation, "auto-annotation", "reloader.stakater.com/auto", "annotation to detect changes in secrets")
+	cmd.PersistentFlags().StringVar(&options.ConfigmapReloaderAutoAnnotation, "configmap-auto-annotation", "configmap.reloader.stakater.com/auto", "annotation to detect changes in configmaps only")
+	cmd.PersistentFlags().StringVar(&options.SecretReloaderAutoAnnotation, "secret-auto-annotation", "secret.reloader.stakater.com/auto", "annotation to detect changes in secrets only")
 	cmd.PersistentFlags().StringVar(&options.AutoSearchAnnotation, "auto-search-annotation", "reloader.stakater.com/search", "annotation to detect changes in configmaps or secrets tagged with special match annotation")
 	cmd.PersistentFlags().StringVar(&options.SearchMatchAnnotation, "search-match-annotation", "reloader.stakater.com/match", "annotation to mark secrets or configmapts to match the search")
+	cmd.PersistentFlags().StringVar(&options.IgnoreResourceAnnotation, "ignore-annotation", "reloader.stakater.com/ignore", "annotation to prevent configmaps or secrets from triggering reloads")
+	cmd.PersistentFlags().StringVar(&options.IgnoreKeysAnnotation, "ignore-keys-annotation", "reloader.stakater.com/ignore-keys", "annotation on configmaps or secrets listing the keys, by name or glob, whose changes never trigger reloads")
+	cmd.PersistentFlags().StringVar(&options.SemanticHashAnnotation, "semantic-hash-annotation", "reloader.stakater.com/semantic-hash", "annotation on configmaps to hash the canonical form of their JSON, YAML, TOML and properties values")
+	cmd.PersistentFlags().StringVar(&options.ReloadTargetsAnnotation, "reload-targets-annotation", "reloader.stakater.com/reload-targets", "annotation on configmaps or secrets listing the workloads to reload, e.g. 'deployment/api,statefulset/db'")
+	cmd.PersistentFlags().StringVar(&options.ReloadConditionAnnotation, "condition-annotation", "reloader.stakater.com/condition", "annotation holding a CEL expression on the old and new configmap or secret which has to be true to reload a workload")
+	cmd.PersistentFlags().StringVar(&options.ReloadOnManagersAnnotation, "reload-on-managers-annotation", "reloader.stakater.com/reload-on-managers", "annotation listing the field managers whose changes of configmaps or secrets reload a workload")
+	cmd.PersistentFlags().StringVar(&options.IgnoreManagersAnnotation, "ignore-managers-annotation", "reloader.stakater.com/ignore-managers", "annotation listing the field managers whose changes of configmaps or secrets never reload a workload")
+	cmd.PersistentFlags().StringVar(&options.ReloadOnDeleteAnnotation, "on-delete-annotation", "reloader.stakater.com/on-delete", "annotation to define whether a workload is reloaded upon deletion of a configmap or secret it uses ('reload', 'ignore' or 'optional')")
+	cmd.PersistentFlags().StringVar(&options.ReloadStrategyAnnotation, "reload-strategy-annotation", "reloader.stakater.com/reload-strategy", "annotation to override the reload strategy of a workload")
+	cmd.PersistentFlags().StringVar(&options.ReloadStrategy, "reload-strategy", constants.EnvVarsReloadStrategy, "strategy to trigger a rolling upgrade, either 'env-vars' or 'annotations'")
 	cmd.PersistentFlags().StringVar(&options.LogFormat, "log-format", "", "Log format to use (empty string for text, or JSON")
 	cmd.PersistentFlags().StringSlice("resources-to-ignore", []string{}, "list of resources to ignore (valid options 'configMaps' or 'secrets')")
+	cmd.PersistentFlags().StringSlice("namespaces", []string{}, "list of namespaces to watch (defaults to the KUBERNETES_NAMESPACE env var, or all namespaces)")
+	cmd.PersistentFlags().StringVar(&options.NamespaceSelector, "namespace-selector", "", "label selector of the namespaces to watch, e.g. 'reloader=enabled'")
+	cmd.PersistentFlags().StringVar(&options.ResourceLabelSelector, "resource-label-selector", "", "label selector of the configmaps and secrets to watch, e.g. 'reloader=enabled'")
+	cmd.PersistentFlags().StringVar(&options.ConfigmapLabelSelector, "configmap-label-selector", "", "label selector of the configmaps to watch, overrides 'resource-label-selector'")
+	cmd.PersistentFlags().StringVar(&options.SecretLabelSelector, "secret-label-selector", "", "label selector of the secrets to watch, overrides 'resource-label-selector'")
+	cmd.PersistentFlags().StringSliceVar(&options.AllowedCrossNamespaceSources, "allow-cross-namespace-sources", []string{}, "list of namespaces whose configmaps and secrets may be referenced as 'namespace/name' by workloads in other namespaces")
+	cmd.PersistentFlags().StringSliceVar(&options.ReloadOnManagers, "reload-on-managers", []string{}, "list of field managers, by name or glob, whose changes of configmaps and secrets trigger reloads, all if empty")
+	cmd.PersistentFlags().StringSliceVar(&options.IgnoredManagers, "ignore-managers", []string{}, "list of field managers, by name or glob, whose changes of configmaps and secrets never trigger reloads")
+	cmd.PersistentFlags().StringSliceVar(&options.IgnoredSecretTypes, "ignore-secret-types", options.IgnoredSecretTypes, "list of secret types to ignore")
 	cmd.PersistentFlags().StringSlice("namespaces-to-ignore", []string{}, "list of namespaces to ignore")
 	cmd.PersistentFlags().StringVar(&options.IsArgoRollouts, "is-Argo-Rollouts", "false", "Add support for argo rollouts")
+	cmd.PersistentFlags().BoolVar(&options.AutoReloadReferencedKeys, "auto-reload-referenced-keys", false, "Reload automatically reloaded workloads only upon changes of the keys referenced by configMapKeyRef, secretKeyRef or volume items")
+	cmd.PersistentFlags().BoolVar(&options.DryRun, "dry-run", false, "Report the workloads which would be reloaded instead of reloading them")
+	cmd.PersistentFlags().StringVar(&options.DryRunAnnotation, "dry-run-annotation", "reloader.stakater.com/dry-run", "annotation on a namespace to override the dry-run mode for its workloads")
+	cmd.PersistentFlags().BoolVar(&options.ReconcileOnStartup, "reconcile-on-startup", false, "Reload the workloads which missed changes of configmaps or secrets while Reloader was down")
+	cmd.PersistentFlags().DurationVar(&options.ResyncPeriod, "resync-period", 0, "interval at which the workloads are compared again with the configmaps and secrets they use (0 to disable)")
+	cmd.PersistentFlags().DurationVar(&options.CacheSyncTimeout, "cache-sync-timeout", options.CacheSyncTimeout, "duration to wait for the caches to sync at startup before falling back to the API server or exiting")
+	cmd.PersistentFlags().BoolVar(&options.EnableHA, "enable-ha", false, "Elect a leader among the Reloader instances so that only one of them reloads workloads")
+	cmd.PersistentFlags().StringVar(&options.LeaderElectionLeaseName, "leader-election-lease-name", "reloader", "name of the Lease used for the leader election")
+	cmd.PersistentFlags().StringVar(&options.LeaderElectionNamespace, "leader-election-namespace", "", "namespace of the Lease used for the leader election (defaults to the POD_NAMESPACE env var)")
+	cmd.PersistentFlags().DurationVar(&options.LeaderElectionLeaseDuration, "leader-election-lease-duration", options.LeaderElectionLeaseDuration, "duration standby instances wait before taking over the leadership")
+	cmd.PersistentFlags().DurationVar(&options.LeaderElectionRenewDeadline, "leader-election-renew-deadline", options.LeaderElectionRenewDeadline, "duration the leader retries to renew its leadership before giving it up")
+	cmd.PersistentFlags().DurationVar(&options.LeaderElectionRetryPeriod, "leader-election-retry-period", options.LeaderElectionRetryPeriod, "duration between attempts to acquire or renew the leadership")
 	return cmd
 }
 
@@ -55,11 +100,22 @@ func startReloader(cmd *cobra.Command, args []string) {
 		logrus.Warn(err)
 	}
 
+	if options.ReloadStrategy != constants.EnvVarsReloadStrategy && options.ReloadStrategy != constants.AnnotationsReloadStrategy {
+		logrus.Fatalf("'reload-strategy' only accepts '%s' or '%s', not '%s'", constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, options.ReloadStrategy)
+	}
+
 	logrus.Info("Starting Reloader")
-	currentNamespace := os.Getenv("KUBERNETES_NAMESPACE")
-	if len(currentNamespace) == 0 {
-		currentNamespace = v1.NamespaceAll
-		logrus.Warnf("KUBERNETES_NAMESPACE is unset, will detect changes in all namespaces.")
+	namespaces, err := getNamespacesList(cmd)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(namespaces) == 0 {
+		currentNamespace := os.Getenv("KUBERNETES_NAMESPACE")
+		if len(currentNamespace) == 0 {
+			currentNamespace = v1.NamespaceAll
+			logrus.Warnf("KUBERNETES_NAMESPACE is unset, will detect changes in all namespaces.")
+		}
+		namespaces = []string{currentNamespace}
 	}
 
 	// create the clientset
@@ -79,28 +135,110 @@ func startReloader(cmd *cobra.Command, args []string) {
 	}
 
 	collectors := metrics.SetupPrometheusEndpoint()
+	events.SetRecorder(events.NewRecorder(clientset))
 
-	for k := range kube.ResourceMap {
-		if ignoredResourcesList.Contains(k) {
-			continue
-		}
+	// Cache the workloads so that the ones referencing a configmap or secret are found without listing them. If the
+	// cache does not sync, e.g. as the RBAC of a previous version does not permit to watch workloads, they are listed
+	workloadCache := workload.NewCache(kube.GetClients(), namespaces)
+	stopWorkloadCache := make(chan struct{})
+	if workloadCache.Run(stopWorkloadCache, options.CacheSyncTimeout) {
+		defer close(stopWorkloadCache)
+		workload.SetSharedCache(workloadCache)
+	} else {
+		close(stopWorkloadCache)
+		logrus.Errorf("Timed out after %s waiting for workload caches to sync, listing workloads from the API server instead. Grant the 'watch' verb on the workloads to cache them", options.CacheSyncTimeout)
+	}
 
-		c, err := controller.NewController(clientset, k, currentNamespace, ignoredNamespacesList, collectors)
+	var namespaceSelector *selector.NamespaceSelector
+	if len(options.NamespaceSelector) > 0 {
+		namespaceSelector, err = selector.NewNamespaceSelector(clientset, options.NamespaceSelector)
 		if err != nil {
-			logrus.Fatalf("%s", err)
+			logrus.Fatalf("Invalid 'namespace-selector': %v", err)
+		}
+		stopNamespaceSelector := make(chan struct{})
+		defer close(stopNamespaceSelector)
+		if !namespaceSelector.Run(stopNamespaceSelector, options.CacheSyncTimeout) {
+			logrus.Fatalf("Timed out after %s waiting for namespace cache to sync, 'namespace-selector' requires the 'list' and 'watch' verbs on 'namespaces'", options.CacheSyncTimeout)
 		}
+		selector.SetSharedNamespaces(namespaceSelector)
+	} else if len(namespaces) == 1 && namespaces[0] == v1.NamespaceAll {
+		// Cache all namespaces once the dry-run annotation of a namespace is read, so that Reloader neither needs to
+		// read namespaces nor waits for them at startup. Namespaces are not cached if only certain namespaces are
+		// watched, as reading them requires cluster-wide permissions
+		selector.SetLazySharedNamespaces(clientset, options.CacheSyncTimeout)
+	}
 
-		// Now let's start the controller
+	if !options.EnableHA {
+		collectors.Leader.Set(1)
 		stop := make(chan struct{})
 		defer close(stop)
-		logrus.Infof("Starting Controller to watch resource type: %s", k)
-		go c.Run(1, stop)
+		startControllers(clientset, namespaces, ignoredResourcesList, ignoredNamespacesList, namespaceSelector, collectors, stop)
+	} else {
+		leaseNamespace := options.LeaderElectionNamespace
+		if len(leaseNamespace) == 0 {
+			leaseNamespace = os.Getenv("POD_NAMESPACE")
+		}
+		if len(leaseNamespace) == 0 {
+			logrus.Fatal("'leader-election-namespace' or the POD_NAMESPACE env var is required when 'enable-ha' is set")
+		}
+
+		// Only the leader runs the controllers, the workload cache is kept warm on the standby instances
+		lock := leadership.GetNewLock(clientset.CoordinationV1(), options.LeaderElectionLeaseName, leadership.GetIdentity(), leaseNamespace)
+		go leadership.RunLeaderElection(context.Background(), lock, collectors, func(ctx context.Context) {
+			startControllers(clientset, namespaces, ignoredResourcesList, ignoredNamespacesList, namespaceSelector, collectors, ctx.Done())
+		}, func() {
+			// Exit so that the instance is restarted as a standby with a clean state
+			logrus.Fatal("Lost the leadership, exiting")
+		})
 	}
 
 	// Wait forever
 	select {}
 }
 
+func startControllers(clientset kubernetes.Interface, namespaces []string, ignoredResourcesList util.List, ignoredNamespacesList util.List, namespaceSelector *selector.NamespaceSelector, collectors metrics.Collectors, stop <-chan struct{}) {
+	for _, namespace := range namespaces {
+		for k := range kube.ResourceMap {
+			if ignoredResourcesList.Contains(k) {
+				continue
+			}
+
+			c, err := controller.NewController(clientset, k, namespace, ignoredNamespacesList, namespaceSelector, collectors)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+
+			// Now let's start the controller
+			if namespace == v1.NamespaceAll {
+				logrus.Infof("Starting Controller to watch resource type: %s", k)
+			} else {
+				logrus.Infof("Starting Controller to watch resource type: %s in namespace: %s", k, namespace)
+			}
+			go c.Run(1, stop)
+		}
+	}
+}
+
+func getNamespacesList(cmd *cobra.Command) ([]string, error) {
+	namespaces, err := getStringSliceFromFlags(cmd, "namespaces")
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop duplicates, so that no namespace is watched twice
+	namespacesList := util.List{}
+	for _, namespace := range namespaces {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			return nil, errors.New("'namespaces' does not accept empty namespaces")
+		}
+		if !namespacesList.Contains(namespace) {
+			namespacesList = append(namespacesList, namespace)
+		}
+	}
+	return namespacesList, nil
+}
+
 func getIgnoredNamespacesList(cmd *cobra.Command) (util.List, error) {
 	return getStringSliceFromFlags(cmd, "namespaces-to-ignore")
 }
@@ -0,0 +1,103 @@
+package condition
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Resource is the state of a configmap or secret a condition is evaluated against
+type Resource struct {
+	Data        map[string]string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func (r Resource) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"data":        nonNil(r.Data),
+		"labels":      nonNil(r.Labels),
+		"annotations": nonNil(r.Annotations),
+	}
+}
+
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+// Condition is a compiled CEL expression deciding whether a workload is reloaded upon the change of a configmap
+// or secret from the old to the new state, e.g. `old.data["version"] != new.data["version"]`
+type Condition struct {
+	program cel.Program
+}
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	// conditions caches the compiled conditions by their expression
+	conditions sync.Map
+)
+
+func getEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		resourceType := decls.NewMapType(decls.String, decls.Dyn)
+		env, envErr = cel.NewEnv(cel.Declarations(
+			decls.NewVar("old", resourceType),
+			decls.NewVar("new", resourceType),
+		))
+	})
+	return env, envErr
+}
+
+// Compile compiles the expression, or returns the condition compiled before for the same expression.
+// An error is returned if the expression is invalid or does not evaluate to a bool
+func Compile(expression string) (*Condition, error) {
+	if condition, found := conditions.Load(expression); found {
+		return condition.(*Condition), nil
+	}
+
+	env, err := getEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if resultType := ast.ResultType(); resultType.GetPrimitive() != exprpb.Type_BOOL && resultType.GetDyn() == nil {
+		return nil, fmt.Errorf("expression must evaluate to a bool")
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	condition := &Condition{program: program}
+	conditions.Store(expression, condition)
+	return condition, nil
+}
+
+// Evaluate evaluates the condition against the old and new state of the configmap or secret. An error is returned
+// if the evaluation fails, e.g. upon access to a missing key, or does not result in a bool
+func (c *Condition) Evaluate(old Resource, new Resource) (bool, error) {
+	value, _, err := c.program.Eval(map[string]interface{}{
+		"old": old.toMap(),
+		"new": new.toMap(),
+	})
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %v instead of a bool", value.Value())
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package condition
+
+import (
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	old := Resource{
+		Data:   map[string]string{"version": "1", "log_level": "info"},
+		Labels: map[string]string{"app": "payments"},
+	}
+	new := Resource{
+		Data:   map[string]string{"version": "1", "log_level": "debug"},
+		Labels: map[string]string{"app": "payments"},
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "TestUnchangedKeyShouldNotMatch", expression: `old.data["version"] != new.data["version"]`, want: false},
+		{name: "TestChangedKeyShouldMatch", expression: `old.data["log_level"] != new.data["log_level"]`, want: true},
+		{name: "TestLabelsShouldBeAvailable", expression: `new.labels["app"] == "payments"`, want: true},
+		{name: "TestMissingAnnotationsShouldBeEmpty", expression: `size(new.annotations) == 0`, want: true},
+		{name: "TestMissingKeyShouldFail", expression: `old.data["missing"] == "1"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, err := Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("Compile() failed: %v", err)
+			}
+			got, err := condition.Evaluate(old, new)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, expression := range []string{`old.data["version"] !=`, `new.data["version"] + "1"`, `unknown == 1`} {
+		if _, err := Compile(expression); err == nil {
+			t.Errorf("Compile() of invalid expression '%s' should fail", expression)
+		}
+	}
+
+	first, err := Compile(`new.data["version"] == "2"`)
+	if err != nil {
+		t.Fatalf("Compile() failed: %v", err)
+	}
+	second, _ := Compile(`new.data["version"] == "2"`)
+	if first != second {
+		t.Errorf("Compile() should return the cached condition for the same expression")
+	}
+}
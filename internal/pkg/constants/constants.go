@@ -7,4 +7,18 @@ const (
 	SecretEnvVarPostfix = "SECRET"
 	// EnvVarPrefix is a Prefix for environment variable
 	EnvVarPrefix = "STAKATER_"
+	// EnvVarsReloadStrategy records the SHA of configmaps and secrets in an env var of a container
+	EnvVarsReloadStrategy = "env-vars"
+	// AnnotationsReloadStrategy records the SHA of configmaps and secrets in the pod template annotations
+	AnnotationsReloadStrategy = "annotations"
+	// LastReloadedFromAnnotation is the pod template annotation describing the source of the last reload
+	LastReloadedFromAnnotation = "reloader.stakater.com/last-reloaded-from"
+	// ReloadedHashesAnnotation is the pod template annotation holding the SHA of every configmap and secret reloaded from
+	ReloadedHashesAnnotation = "reloader.stakater.com/last-reloaded-hashes"
+	// ReloadOnDelete reloads the workload whenever a referenced configmap or secret is deleted
+	ReloadOnDelete = "reload"
+	// IgnoreOnDelete never reloads the workload when a referenced configmap or secret is deleted
+	IgnoreOnDelete = "ignore"
+	// OptionalOnDelete reloads the workload only if the deleted configmap or secret is referenced as optional
+	OptionalOnDelete = "optional"
 )
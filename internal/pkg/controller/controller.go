@@ -2,14 +2,21 @@ package controller
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/constants"
 	"github.com/stakater/Reloader/internal/pkg/handler"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/selector"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -27,60 +34,144 @@ type Controller struct {
 	informer          cache.Controller
 	namespace         string
 	ignoredNamespaces util.List
+	namespaceSelector *selector.NamespaceSelector
 	collectors        metrics.Collectors
+	// labelSelected is set if only the configmaps or secrets matching a label selector are watched
+	labelSelected bool
+	// initialized is set atomically to 1 once the initial list of the controller has been handled
+	initialized int32
 }
 
-// controllerInitialized flag determines whether controlled is being initialized
-var controllerInitialized bool = false
-
 // NewController for initializing a Controller
 func NewController(
-	client kubernetes.Interface, resource string, namespace string, ignoredNamespaces []string, collectors metrics.Collectors) (*Controller, error) {
+	client kubernetes.Interface, resource string, namespace string, ignoredNamespaces []string, namespaceSelector *selector.NamespaceSelector, collectors metrics.Collectors) (*Controller, error) {
 
 	c := Controller{
 		client:            client,
 		namespace:         namespace,
 		ignoredNamespaces: ignoredNamespaces,
+		namespaceSelector: namespaceSelector,
+	}
+
+	labelSelector, err := getLabelSelector(resource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector for %s: %v", resource, err)
 	}
+	c.labelSelected = !labelSelector.Empty()
 
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
-	listWatcher := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), resource, namespace, fields.Everything())
+	// The label selector is evaluated by the API server, so that configmaps and secrets not matching it are never cached
+	listWatcher := cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), resource, namespace, func(listOptions *metav1.ListOptions) {
+		listOptions.FieldSelector = getFieldSelector(resource).String()
+		listOptions.LabelSelector = labelSelector.String()
+	})
 
-	indexer, informer := cache.NewIndexerInformer(listWatcher, kube.ResourceMap[resource], 0, cache.ResourceEventHandlerFuncs{
+	indexer, informer := cache.NewIndexerInformer(listWatcher, kube.ResourceMap[resource], options.ResyncPeriod, cache.ResourceEventHandlerFuncs{
 		AddFunc:    c.Add,
 		UpdateFunc: c.Update,
 		DeleteFunc: c.Delete,
 	}, cache.Indexers{})
 	c.indexer = indexer
 	c.informer = informer
+	handler.RegisterSourceCache(getResourceType(resource), namespace, indexer)
 	c.queue = queue
 	c.collectors = collectors
 	return &c, nil
 }
 
+// getResourceType returns the type of the configmaps or secrets as used in the configs of the handlers
+func getResourceType(resource string) string {
+	if resource == "secrets" {
+		return constants.SecretEnvVarPostfix
+	}
+	return constants.ConfigmapEnvVarPostfix
+}
+
+// getLabelSelector returns the label selector of the configmaps or secrets to watch
+func getLabelSelector(resource string) (labels.Selector, error) {
+	labelSelector := options.ResourceLabelSelector
+	if resource == "configMaps" && len(options.ConfigmapLabelSelector) > 0 {
+		labelSelector = options.ConfigmapLabelSelector
+	} else if resource == "secrets" && len(options.SecretLabelSelector) > 0 {
+		labelSelector = options.SecretLabelSelector
+	}
+	return labels.Parse(labelSelector)
+}
+
+// getFieldSelector returns the field selector of the configmaps or secrets to watch, which excludes the ignored secret types
+func getFieldSelector(resource string) fields.Selector {
+	if resource != "secrets" || len(options.IgnoredSecretTypes) == 0 {
+		return fields.Everything()
+	}
+
+	selectors := []fields.Selector{}
+	for _, secretType := range options.IgnoredSecretTypes {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("type", secretType))
+	}
+	return fields.AndSelectors(selectors...)
+}
+
 // Add function to add a new object to the queue in case of creating a resource
 func (c *Controller) Add(obj interface{}) {
-	if !c.resourceInIgnoredNamespace(obj) && controllerInitialized {
+	if c.resourceIgnored(obj) {
+		return
+	}
+
+	if c.isInitialized() {
 		c.queue.Add(handler.ResourceCreatedHandler{
 			Resource:   obj,
 			Collectors: c.collectors,
 		})
+	} else if options.ReconcileOnStartup {
+		// The resource is part of the initial list, it may have changed while Reloader was down
+		c.queue.Add(handler.ResourceReconciledHandler{
+			Resource:   obj,
+			Collectors: c.collectors,
+		})
+	}
+}
+
+// resourceIgnored checks whether the resource is in an ignored namespace or is a secret of an ignored type
+func (c *Controller) resourceIgnored(raw interface{}) bool {
+	if secret, ok := raw.(*v1.Secret); ok {
+		ignoredSecretTypes := util.List(options.IgnoredSecretTypes)
+		if ignoredSecretTypes.Contains(string(secret.Type)) {
+			return true
+		}
 	}
+	return c.resourceInIgnoredNamespace(raw)
 }
 
 func (c *Controller) resourceInIgnoredNamespace(raw interface{}) bool {
 	switch object := raw.(type) {
 	case *v1.ConfigMap:
-		return c.ignoredNamespaces.Contains(object.ObjectMeta.Namespace)
+		return c.namespaceIgnored(object.ObjectMeta.Namespace)
 	case *v1.Secret:
-		return c.ignoredNamespaces.Contains(object.ObjectMeta.Namespace)
+		return c.namespaceIgnored(object.ObjectMeta.Namespace)
 	}
 	return false
 }
 
+// namespaceIgnored checks whether the namespace is ignored or, if a namespace selector is set, not selected
+func (c *Controller) namespaceIgnored(namespace string) bool {
+	if c.ignoredNamespaces.Contains(namespace) {
+		return true
+	}
+	return c.namespaceSelector != nil && !c.namespaceSelector.Matches(namespace)
+}
+
 // Update function to add an old object and a new object to the queue in case of updating a resource
 func (c *Controller) Update(old interface{}, new interface{}) {
-	if !c.resourceInIgnoredNamespace(new) {
+	if c.resourceIgnored(new) {
+		return
+	}
+
+	if isResync(old, new) {
+		c.queue.Add(handler.ResourceReconciledHandler{
+			Resource:   new,
+			Collectors: c.collectors,
+		})
+	} else {
 		c.queue.Add(handler.ResourceUpdatedHandler{
 			Resource:    new,
 			OldResource: old,
@@ -89,13 +180,42 @@ func (c *Controller) Update(old interface{}, new interface{}) {
 	}
 }
 
+// isResync checks whether the update was sent by a periodic resync of the informer, which hands over the unchanged resource
+func isResync(old interface{}, new interface{}) bool {
+	oldMeta, err := meta.Accessor(old)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(new)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
 // Delete function to add an object to the queue in case of deleting a resource
 func (c *Controller) Delete(old interface{}) {
-	// Todo: Any future delete event can be handled here
+	// The informer hands over a tombstone if it missed the deletion, the last known state is inside it
+	if tombstone, ok := old.(cache.DeletedFinalStateUnknown); ok {
+		old = tombstone.Obj
+	}
+
+	if !c.resourceIgnored(old) {
+		deletedHandler := handler.ResourceDeletedHandler{
+			Resource:   old,
+			Collectors: c.collectors,
+		}
+		// The watch also reports resources as deleted which no longer match the label selector, e.g. after they were
+		// relabeled, so the handler checks whether they still exist
+		if c.labelSelected {
+			deletedHandler.Client = c.client
+		}
+		c.queue.Add(deletedHandler)
+	}
 }
 
 //Run function for controller which handles the queue
-func (c *Controller) Run(threadiness int, stopCh chan struct{}) {
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 
 	// Let the workers stop when we are done
@@ -108,6 +228,8 @@ func (c *Controller) Run(threadiness int, stopCh chan struct{}) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
+	// The handlers of the informer were called for the whole initial list, later additions are new resources
+	atomic.StoreInt32(&c.initialized, 1)
 
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
@@ -117,10 +239,12 @@ func (c *Controller) Run(threadiness int, stopCh chan struct{}) {
 	logrus.Infof("Stopping Controller")
 }
 
-func (c *Controller) runWorker() {
-	// At this point the controller is fully initialized and we can start processing the resources
-	controllerInitialized = true
+// isInitialized checks whether the initial list of the controller has been handled
+func (c *Controller) isInitialized() bool {
+	return atomic.LoadInt32(&c.initialized) == 1
+}
 
+func (c *Controller) runWorker() {
 	for c.processNextItem() {
 	}
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,7 +15,9 @@ import (
 	"github.com/stakater/Reloader/internal/pkg/testutil"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
+	testclient "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -40,7 +43,7 @@ func TestMain(m *testing.M) {
 
 	logrus.Infof("Creating controller")
 	for k := range kube.ResourceMap {
-		c, err := NewController(clients.KubernetesClient, k, namespace, []string{}, collectors)
+		c, err := NewController(clients.KubernetesClient, k, namespace, []string{}, nil, collectors)
 		if err != nil {
 			logrus.Fatalf("%s", err)
 		}
@@ -1177,3 +1180,201 @@ func TestController_resourceInIgnoredNamespace(t *testing.T) {
 		})
 	}
 }
+
+func TestController_DeleteShouldUnwrapTombstone(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+	}{
+		{
+			name: "TestDeletedConfigMapShouldBeQueued",
+			obj:  testutil.GetConfigmap("test", "testcm", "test"),
+		},
+		{
+			name: "TestDeletedConfigMapTombstoneShouldBeQueued",
+			obj: cache.DeletedFinalStateUnknown{
+				Key: "test/testcm",
+				Obj: testutil.GetConfigmap("test", "testcm", "test"),
+			},
+		},
+		{
+			name: "TestDeletedSecretTombstoneShouldBeQueued",
+			obj: cache.DeletedFinalStateUnknown{
+				Key: "test/testsecret",
+				Obj: testutil.GetSecret("test", "testsecret", "test"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+				ignoredNamespaces: util.List{},
+			}
+			defer c.queue.ShutDown()
+
+			c.Delete(tt.obj)
+			if c.queue.Len() != 1 {
+				t.Fatalf("Controller.Delete() queued %d items, want 1", c.queue.Len())
+			}
+			item, _ := c.queue.Get()
+			deletedHandler, ok := item.(handler.ResourceDeletedHandler)
+			if !ok {
+				t.Fatalf("Controller.Delete() queued %T, want handler.ResourceDeletedHandler", item)
+			}
+			if _, ok := deletedHandler.Resource.(cache.DeletedFinalStateUnknown); ok {
+				t.Errorf("Controller.Delete() queued the tombstone instead of the deleted object")
+			}
+		})
+	}
+}
+
+func TestController_DeleteShouldCheckExistenceOnlyWithLabelSelector(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	for _, labelSelected := range []bool{false, true} {
+		c := &Controller{
+			client:            client,
+			queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+			ignoredNamespaces: util.List{},
+			labelSelected:     labelSelected,
+		}
+
+		c.Delete(testutil.GetConfigmap("test", "testcm", "test"))
+		item, _ := c.queue.Get()
+		deletedHandler, ok := item.(handler.ResourceDeletedHandler)
+		if !ok {
+			t.Fatalf("Controller.Delete() queued %T, want handler.ResourceDeletedHandler", item)
+		}
+		if checked := deletedHandler.Client != nil; checked != labelSelected {
+			t.Errorf("Controller.Delete() checks the existence of the configmap = %t with label selector %t", checked, labelSelected)
+		}
+		c.queue.Done(item)
+		c.queue.ShutDown()
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("Controller.Delete() sent %d requests to the API server, want 0", len(client.Actions()))
+	}
+}
+
+func TestController_UpdateShouldReconcileOnResync(t *testing.T) {
+	c := &Controller{
+		queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		ignoredNamespaces: util.List{},
+	}
+	defer c.queue.ShutDown()
+
+	configmap := testutil.GetConfigmap("test", "testcm", "test")
+	configmap.ResourceVersion = "1"
+	c.Update(configmap, configmap)
+	item, _ := c.queue.Get()
+	if _, ok := item.(handler.ResourceReconciledHandler); !ok {
+		t.Errorf("Controller.Update() queued %T on resync, want handler.ResourceReconciledHandler", item)
+	}
+	c.queue.Done(item)
+
+	updatedConfigmap := testutil.GetConfigmap("test", "testcm", "test-updated")
+	updatedConfigmap.ResourceVersion = "2"
+	c.Update(configmap, updatedConfigmap)
+	item, _ = c.queue.Get()
+	if _, ok := item.(handler.ResourceUpdatedHandler); !ok {
+		t.Errorf("Controller.Update() queued %T on change, want handler.ResourceUpdatedHandler", item)
+	}
+	c.queue.Done(item)
+}
+
+func TestGetLabelSelector(t *testing.T) {
+	options.ResourceLabelSelector = "reloader=enabled"
+	options.SecretLabelSelector = "owner!=helm"
+	defer func() {
+		options.ResourceLabelSelector = ""
+		options.SecretLabelSelector = ""
+	}()
+
+	tests := []struct {
+		name     string
+		resource string
+		want     string
+	}{
+		{
+			name:     "TestConfigMapsShouldUseResourceLabelSelector",
+			resource: "configMaps",
+			want:     "reloader=enabled",
+		},
+		{
+			name:     "TestSecretsShouldUseSecretLabelSelector",
+			resource: "secrets",
+			want:     "owner!=helm",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labelSelector, err := getLabelSelector(tt.resource)
+			if err != nil {
+				t.Fatalf("getLabelSelector() failed: %v", err)
+			}
+			if labelSelector.String() != tt.want {
+				t.Errorf("getLabelSelector() = %s, want %s", labelSelector.String(), tt.want)
+			}
+		})
+	}
+
+	options.ConfigmapLabelSelector = "reloader in (enabled"
+	defer func() {
+		options.ConfigmapLabelSelector = ""
+	}()
+	if _, err := NewController(clients.KubernetesClient, "configMaps", namespace, []string{}, nil, collectors); err == nil {
+		t.Errorf("NewController() accepted an invalid label selector")
+	}
+}
+
+func TestController_resourceIgnoredBySecretType(t *testing.T) {
+	c := &Controller{
+		ignoredNamespaces: util.List{},
+	}
+
+	helmSecret := testutil.GetSecret("test", "sh.helm.release.v1.app.v1", "test")
+	helmSecret.Type = "helm.sh/release.v1"
+	if !c.resourceIgnored(helmSecret) {
+		t.Errorf("Controller.resourceIgnored() = false for a helm release secret, want true")
+	}
+
+	opaqueSecret := testutil.GetSecret("test", "testsecret", "test")
+	opaqueSecret.Type = v1.SecretTypeOpaque
+	if c.resourceIgnored(opaqueSecret) {
+		t.Errorf("Controller.resourceIgnored() = true for an opaque secret, want false")
+	}
+
+	want := "type!=helm.sh/release.v1,type!=kubernetes.io/service-account-token"
+	if got := getFieldSelector("secrets").String(); got != want {
+		t.Errorf("getFieldSelector() = %s, want %s", got, want)
+	}
+	if got := getFieldSelector("configMaps").String(); got != "" {
+		t.Errorf("getFieldSelector() = %s for configmaps, want an empty selector", got)
+	}
+}
+
+func TestController_AddShouldReconcileUntilInitialized(t *testing.T) {
+	options.ReconcileOnStartup = true
+	defer func() { options.ReconcileOnStartup = false }()
+	c := &Controller{
+		queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		ignoredNamespaces: util.List{},
+	}
+	defer c.queue.ShutDown()
+
+	configmap := testutil.GetConfigmap("test", "testcm", "test")
+	c.Add(configmap)
+	item, _ := c.queue.Get()
+	if _, ok := item.(handler.ResourceReconciledHandler); !ok {
+		t.Errorf("Controller.Add() queued %T during the initial list, want handler.ResourceReconciledHandler", item)
+	}
+	c.queue.Done(item)
+
+	atomic.StoreInt32(&c.initialized, 1)
+	c.Add(configmap)
+	item, _ = c.queue.Get()
+	if _, ok := item.(handler.ResourceCreatedHandler); !ok {
+		t.Errorf("Controller.Add() queued %T after the initial list, want handler.ResourceCreatedHandler", item)
+	}
+	c.queue.Done(item)
+}
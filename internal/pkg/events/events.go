@@ -0,0 +1,57 @@
+package events
+
+import (
+	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	openshiftv1 "github.com/openshift/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ReasonReload is the reason of the event recorded on a workload which has been reloaded
+	ReasonReload = "Reload"
+	// ReasonDryRunReload is the reason of the event recorded on a workload which would have been reloaded in dry-run mode
+	ReasonDryRunReload = "DryRunReload"
+	// ReasonInvalidPattern is the reason of the event recorded on a workload with an invalid pattern in a reload annotation
+	ReasonInvalidPattern = "InvalidPattern"
+	// ReasonInvalidSelector is the reason of the event recorded on a workload with an invalid label selector in a selector annotation
+	ReasonInvalidSelector = "InvalidSelector"
+	// ReasonInvalidCondition is the reason of the event recorded on a workload with an invalid condition annotation
+	ReasonInvalidCondition = "InvalidCondition"
+)
+
+// scheme contains the kinds of all workloads, so that events can reference them
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(openshiftv1.Install(scheme))
+	utilruntime.Must(argorolloutv1alpha1.AddToScheme(scheme))
+}
+
+var recorder record.EventRecorder
+
+// NewRecorder returns an event recorder sending the events of Reloader to the API server
+func NewRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, v1.EventSource{Component: "reloader"})
+}
+
+// SetRecorder sets the event recorder used by Record
+func SetRecorder(r record.EventRecorder) {
+	recorder = r
+}
+
+// Record records an event on the object, it is a no-op until a recorder is set
+func Record(object runtime.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
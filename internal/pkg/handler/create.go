@@ -19,6 +19,9 @@ func (r ResourceCreatedHandler) Handle() error {
 		logrus.Errorf("Resource creation handler received nil resource")
 	} else {
 		config, _ := r.GetConfig()
+		if isResourceIgnored(config) {
+			return nil
+		}
 		// process resource based on its type
 		return doRollingUpgrade(config, r.Collectors)
 	}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResourceDeletedHandler contains deleted objects
+type ResourceDeletedHandler struct {
+	Resource   interface{}
+	Collectors metrics.Collectors
+	// Client is set to check whether the resource still exists before handling its deletion
+	Client kubernetes.Interface
+}
+
+// Handle processes the deleted resource
+func (r ResourceDeletedHandler) Handle() error {
+	if r.Resource == nil {
+		logrus.Errorf("Resource delete handler received nil resource")
+	} else {
+		config, _ := r.GetConfig()
+		if isResourceIgnored(config) {
+			return nil
+		}
+		deleted, err := r.resourceDeleted()
+		if err != nil || !deleted {
+			return err
+		}
+		// process resource based on its type
+		return doRollingUpgrade(config, r.Collectors)
+	}
+	return nil
+}
+
+// resourceDeleted checks whether the resource is really gone if a client is set. The watch also reports resources as
+// deleted which no longer match the label selector, e.g. after they were relabeled, those must not be handled as deletions
+func (r ResourceDeletedHandler) resourceDeleted() (bool, error) {
+	if r.Client == nil {
+		return true, nil
+	}
+
+	var current metav1.Object
+	var err error
+	switch object := r.Resource.(type) {
+	case *v1.ConfigMap:
+		current, err = r.Client.CoreV1().ConfigMaps(object.Namespace).Get(context.TODO(), object.Name, metav1.GetOptions{})
+	case *v1.Secret:
+		current, err = r.Client.CoreV1().Secrets(object.Namespace).Get(context.TODO(), object.Name, metav1.GetOptions{})
+	default:
+		return true, nil
+	}
+
+	objectMeta, _ := meta.Accessor(r.Resource)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to check whether '%s' in namespace '%s' was deleted: %v", objectMeta.GetName(), objectMeta.GetNamespace(), err)
+	}
+	// A resource recreated with the same name was deleted in between
+	if current.GetUID() != objectMeta.GetUID() {
+		return true, nil
+	}
+	logrus.Debugf("Ignoring deletion of '%s' in namespace '%s' as it still exists and no longer matches the label selector", objectMeta.GetName(), objectMeta.GetNamespace())
+	return false, nil
+}
+
+// GetConfig gets configurations containing the deleted SHA, annotations, namespace and resource name
+func (r ResourceDeletedHandler) GetConfig() (util.Config, string) {
+	var oldSHAData string
+	var config util.Config
+	if _, ok := r.Resource.(*v1.ConfigMap); ok {
+		oldSHAData = util.GetSHAfromConfigmap(r.Resource.(*v1.ConfigMap))
+		config = util.GetConfigmapConfig(r.Resource.(*v1.ConfigMap))
+	} else if _, ok := r.Resource.(*v1.Secret); ok {
+		oldSHAData = util.GetSHAfromSecret(r.Resource.(*v1.Secret))
+		config = util.GetSecretConfig(r.Resource.(*v1.Secret))
+	} else {
+		logrus.Warnf("Invalid resource: Resource should be 'Secret' or 'Configmap' but found, %v", r.Resource)
+		return config, oldSHAData
+	}
+	config.SHAValue = util.GetSHAfromDeletedResource()
+	config.Deleted = true
+	return config, oldSHAData
+}
@@ -1,11 +1,25 @@
 package handler
 
 import (
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/options"
 	"github.com/stakater/Reloader/internal/pkg/util"
 )
 
-// ResourceHandler handles the creation and update of resources
+// ResourceHandler handles the creation, update, deletion and reconciliation of resources
 type ResourceHandler interface {
 	Handle() error
 	GetConfig() (util.Config, string)
 }
+
+// isResourceIgnored checks whether the configmap or secret opted out of triggering reloads with the ignore annotation
+func isResourceIgnored(config util.Config) bool {
+	ignored, err := strconv.ParseBool(config.ResourceAnnotations[options.IgnoreResourceAnnotation])
+	if err != nil || !ignored {
+		return false
+	}
+	logrus.Debugf("Ignoring changes in '%s' of type '%s' in namespace '%s' as it is annotated with '%s'", config.ResourceName, config.Type, config.Namespace, options.IgnoreResourceAnnotation)
+	return true
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ResourceReconciledHandler contains objects whose changes may have been missed
+type ResourceReconciledHandler struct {
+	Resource   interface{}
+	Collectors metrics.Collectors
+}
+
+// Handle reloads the workloads which recorded an outdated SHA of the resource
+func (r ResourceReconciledHandler) Handle() error {
+	if r.Resource == nil {
+		logrus.Errorf("Resource reconcile handler received nil resource")
+	} else {
+		config, _ := r.GetConfig()
+		if isResourceIgnored(config) {
+			return nil
+		}
+		// process resource based on its type
+		return doRollingUpgrade(config, r.Collectors)
+	}
+	return nil
+}
+
+// GetConfig gets configurations containing SHA, annotations, namespace and resource name
+func (r ResourceReconciledHandler) GetConfig() (util.Config, string) {
+	var oldSHAData string
+	var config util.Config
+	if _, ok := r.Resource.(*v1.ConfigMap); ok {
+		config = util.GetConfigmapConfig(r.Resource.(*v1.ConfigMap))
+	} else if _, ok := r.Resource.(*v1.Secret); ok {
+		config = util.GetSecretConfig(r.Resource.(*v1.Secret))
+	} else {
+		logrus.Warnf("Invalid resource: Resource should be 'Secret' or 'Configmap' but found, %v", r.Resource)
+		return config, oldSHAData
+	}
+	config.Reconcile = true
+	return config, oldSHAData
+}
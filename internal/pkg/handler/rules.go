@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Names of the rules deciding whether a workload is reloaded, as used in logs and metrics
+const (
+	RuleAuto          = "auto"
+	RuleReference     = "reference"
+	RuleSelector      = "selector"
+	RuleReloadTargets = "reload-targets"
+	RuleSearch        = "search"
+)
+
+// ruleMatch is the decision of a rule to reload an item with the config, the reason explains why the rule matched
+type ruleMatch struct {
+	rule       string
+	reason     string
+	config     util.Config
+	autoReload bool
+	// pattern is set if the configmap or secret is matched by a glob or a regular expression
+	pattern bool
+}
+
+// rule evaluates whether an item is reloaded upon changes of the configmap or secret
+type rule struct {
+	name     string
+	evaluate func(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch
+}
+
+// rules are evaluated in this order. An item is reloaded by the configmaps and secrets matched by any rule, i.e. the
+// union of the matches of all rules, and the first match which changes the item decides how it is reloaded
+var rules = []rule{
+	{name: RuleAuto, evaluate: evaluateAutoRule},
+	{name: RuleReference, evaluate: evaluateReferenceRule},
+	{name: RuleSelector, evaluate: evaluateSelectorRule},
+	{name: RuleReloadTargets, evaluate: evaluateReloadTargetsRule},
+	{name: RuleSearch, evaluate: evaluateSearchRule},
+}
+
+// evaluateRules returns the matches of all rules for the item in the order of the rules
+func evaluateRules(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	matches := []ruleMatch{}
+	for _, r := range rules {
+		for _, match := range r.evaluate(upgradeFuncs, item, config) {
+			match.rule = r.name
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// getAnnotation returns the annotation of the item, or of its pod template if the item does not have it
+func getAnnotation(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, name string) (string, bool) {
+	if value, found := upgradeFuncs.AnnotationsFunc(item)[name]; found {
+		return value, true
+	}
+	value, found := upgradeFuncs.PodAnnotationsFunc(item)[name]
+	return value, found
+}
+
+// evaluateAutoRule matches the configmaps and secrets used by items with the auto annotation, unless they are excluded.
+// The auto annotation of the type of the configmap or secret overrides the one of both types
+func evaluateAutoRule(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	// Workloads in other namespaces are only reloaded by configmaps or secrets they reference by namespace
+	if config.CrossNamespace {
+		return nil
+	}
+
+	annotation := config.AutoAnnotation
+	value, found := getAnnotation(upgradeFuncs, item, annotation)
+	if !found {
+		annotation = options.ReloaderAutoAnnotation
+		value, _ = getAnnotation(upgradeFuncs, item, annotation)
+	}
+	if enabled, err := strconv.ParseBool(value); err != nil || !enabled {
+		return nil
+	}
+
+	excludeValue, _ := getAnnotation(upgradeFuncs, item, config.ExcludeAnnotation)
+	if isExcluded(upgradeFuncs, item, excludeValue, config) {
+		return nil
+	}
+	return []ruleMatch{{
+		reason:     fmt.Sprintf("annotated with '%s'", annotation),
+		config:     config,
+		autoReload: true,
+	}}
+}
+
+// evaluateReferenceRule matches the configmaps and secrets named in the reload annotation of the item, whose watched
+// keys changed
+func evaluateReferenceRule(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	value, _ := getAnnotation(upgradeFuncs, item, config.Annotation)
+	matches := []ruleMatch{}
+	for _, reference := range util.ParseResourceReferences(value) {
+		if !referencesNamespace(upgradeFuncs, item, reference, config) {
+			continue
+		}
+		referenceMatches, err := reference.Matches(config.ResourceName)
+		if err != nil {
+			reportInvalidPattern(upgradeFuncs, item, reference.Name, err)
+			continue
+		}
+		if referenceMatches && keysChanged(config, reference.Keys) {
+			matches = append(matches, ruleMatch{
+				reason:  fmt.Sprintf("named '%s' in annotation '%s'", reference.Name, config.Annotation),
+				config:  getKeysConfig(config, reference.Keys),
+				pattern: reference.IsPattern(),
+			})
+		}
+	}
+	return matches
+}
+
+// evaluateSelectorRule matches the configmaps and secrets whose labels match the selector annotation of the item
+func evaluateSelectorRule(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	value, _ := getAnnotation(upgradeFuncs, item, config.SelectorAnnotation)
+	if strings.TrimSpace(value) == "" || config.CrossNamespace {
+		return nil
+	}
+
+	selector, err := labels.Parse(value)
+	if err != nil {
+		reportInvalidSelector(upgradeFuncs, item, value, err)
+		return nil
+	}
+	if !selector.Matches(labels.Set(config.ResourceLabels)) {
+		return nil
+	}
+	return []ruleMatch{{
+		reason: fmt.Sprintf("labels match selector '%s' in annotation '%s'", value, config.SelectorAnnotation),
+		config: config,
+	}}
+}
+
+// evaluateReloadTargetsRule matches the configmaps and secrets listing the item in their reload targets annotation
+func evaluateReloadTargetsRule(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	if !isReloadTarget(upgradeFuncs, item, config) {
+		return nil
+	}
+	return []ruleMatch{{
+		reason: fmt.Sprintf("listed in annotation '%s' of the %s", options.ReloadTargetsAnnotation, strings.ToLower(config.Type)),
+		config: config,
+	}}
+}
+
+// evaluateSearchRule matches the configmaps and secrets with the match annotation used by items with the search annotation
+func evaluateSearchRule(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []ruleMatch {
+	value, _ := getAnnotation(upgradeFuncs, item, options.AutoSearchAnnotation)
+	if value != "true" || config.CrossNamespace || config.ResourceAnnotations[options.SearchMatchAnnotation] != "true" {
+		return nil
+	}
+	return []ruleMatch{{
+		reason:     fmt.Sprintf("annotated with '%s' and the %s with '%s'", options.AutoSearchAnnotation, strings.ToLower(config.Type), options.SearchMatchAnnotation),
+		config:     config,
+		autoReload: true,
+	}}
+}
+
+// reloadItem reloads the item with the first match of the rules which changes it, and returns the match. If the match
+// is a pattern, the SHAs recorded for configmaps or secrets which no longer exist are removed from the item
+func reloadItem(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) (constants.Result, ruleMatch) {
+	for _, match := range evaluateRules(upgradeFuncs, item, config) {
+		var result constants.Result
+		if match.autoReload {
+			result = invokeAutoReloadStrategy(upgradeFuncs, item, match.config)
+		} else {
+			result = invokeReloadStrategy(upgradeFuncs, item, match.config, false)
+		}
+		if result == constants.Updated {
+			if match.pattern {
+				removeStaleEntries(upgradeFuncs, item, match.config)
+			}
+			return result, match
+		}
+	}
+	return constants.NotUpdated, ruleMatch{}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+// sourceCache is the cache of the configmaps or secrets watched by a controller in a namespace, all if empty
+type sourceCache struct {
+	namespace string
+	store     cache.Store
+}
+
+var (
+	sourceCachesMutex sync.RWMutex
+	// sourceCaches holds the caches of the watched configmaps and secrets by their type
+	sourceCaches = map[string][]sourceCache{}
+)
+
+// RegisterSourceCache registers the cache of the configmaps or secrets of given type, e.g. constants.ConfigmapEnvVarPostfix,
+// watched in the namespace, all if empty. The SHAs recorded for configmaps or secrets which are no longer cached are removed
+// from workloads reloaded by a pattern
+func RegisterSourceCache(resourceType string, namespace string, store cache.Store) {
+	sourceCachesMutex.Lock()
+	defer sourceCachesMutex.Unlock()
+	sourceCaches[resourceType] = append(sourceCaches[resourceType], sourceCache{namespace: namespace, store: store})
+}
+
+// getSourceNames returns the names of the cached configmaps or secrets of given type in the namespace. It returns
+// false if the namespace is not cached
+func getSourceNames(resourceType string, namespace string) (map[string]bool, bool) {
+	sourceCachesMutex.RLock()
+	defer sourceCachesMutex.RUnlock()
+
+	names := map[string]bool{}
+	cached := false
+	for _, sourceCache := range sourceCaches[resourceType] {
+		if sourceCache.namespace != "" && sourceCache.namespace != namespace {
+			continue
+		}
+		cached = true
+		for _, object := range sourceCache.store.List() {
+			if objectMeta, err := meta.Accessor(object); err == nil && objectMeta.GetNamespace() == namespace {
+				names[objectMeta.GetName()] = true
+			}
+		}
+	}
+	return names, cached
+}
+
+// removeStaleEntries removes the SHAs recorded on the item for configmaps or secrets of the type of the config which no
+// longer exist. Patterns match generated names, so every generation would otherwise record a SHA which is never removed.
+// Nothing is removed unless the configmaps or secrets of every namespace the item may reference are cached
+func removeStaleEntries(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) {
+	itemNamespace := util.ToObjectMeta(item).Namespace
+	namespaces := append([]string{itemNamespace}, options.AllowedCrossNamespaceSources...)
+
+	// The recorded names of the existing configmaps or secrets, prefixed by their namespace if it is not the one of the item
+	existing := map[string]bool{}
+	for _, namespace := range namespaces {
+		names, cached := getSourceNames(config.Type, namespace)
+		if !cached {
+			return
+		}
+		for name := range names {
+			recorded := util.Config{Namespace: namespace, ResourceName: name, Type: config.Type, CrossNamespace: namespace != itemNamespace}
+			existing[getRecordedName(recorded)] = true
+			existing[getEnvVarName(recorded)] = true
+		}
+	}
+
+	// The configmap or secret which has just been recorded is kept even if it has been deleted
+	existing[getRecordedName(config)] = true
+	existing[getEnvVarName(config)] = true
+
+	if getReloadStrategy(upgradeFuncs, item) == constants.AnnotationsReloadStrategy {
+		removeStaleHashes(upgradeFuncs, item, config, existing)
+	} else {
+		removeStaleEnvVars(upgradeFuncs, item, config, existing)
+	}
+}
+
+// removeStaleHashes removes the SHAs of configmaps or secrets which no longer exist from the ReloadedHashesAnnotation
+func removeStaleHashes(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config, existing map[string]bool) {
+	hashes := getReloadedHashes(upgradeFuncs, item)
+	prefix := strings.ToLower(config.Type) + "/"
+	removed := false
+	for key := range hashes {
+		if strings.HasPrefix(key, prefix) && !existing[strings.TrimPrefix(key, prefix)] {
+			delete(hashes, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+
+	hashesValue, err := json.Marshal(hashes)
+	if err != nil {
+		logrus.Errorf("Failed to marshal annotation '%s': %v", constants.ReloadedHashesAnnotation, err)
+		return
+	}
+	upgradeFuncs.PodAnnotationsFunc(item)[constants.ReloadedHashesAnnotation] = string(hashesValue)
+}
+
+// removeStaleEnvVars removes the env vars recording the SHAs of configmaps or secrets which no longer exist
+func removeStaleEnvVars(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config, existing map[string]bool) {
+	suffix := "_" + config.Type
+	containers := upgradeFuncs.ContainersFunc(item)
+	for i := range containers {
+		envs := containers[i].Env[:0]
+		for _, env := range containers[i].Env {
+			if strings.HasPrefix(env.Name, constants.EnvVarPrefix) && strings.HasSuffix(env.Name, suffix) && !existing[env.Name] {
+				continue
+			}
+			envs = append(envs, env)
+		}
+		containers[i].Env = envs
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ResourceUpdatedHandler contains updated objects
@@ -20,7 +21,7 @@ func (r ResourceUpdatedHandler) Handle() error {
 		logrus.Errorf("Resource update handler received nil resource")
 	} else {
 		config, oldSHAData := r.GetConfig()
-		if config.SHAValue != oldSHAData {
+		if config.SHAValue != oldSHAData && !isResourceIgnored(config) {
 			// process resource based on its type
 			return doRollingUpgrade(config, r.Collectors)
 		}
@@ -32,14 +33,25 @@ func (r ResourceUpdatedHandler) Handle() error {
 func (r ResourceUpdatedHandler) GetConfig() (util.Config, string) {
 	var oldSHAData string
 	var config util.Config
+	var managedFields []metav1.ManagedFieldsEntry
 	if _, ok := r.Resource.(*v1.ConfigMap); ok {
 		oldSHAData = util.GetSHAfromConfigmap(r.OldResource.(*v1.ConfigMap))
 		config = util.GetConfigmapConfig(r.Resource.(*v1.ConfigMap))
+		config.OldData = util.GetConfigmapData(r.OldResource.(*v1.ConfigMap))
+		config.OldLabels = r.OldResource.(*v1.ConfigMap).Labels
+		config.OldAnnotations = r.OldResource.(*v1.ConfigMap).Annotations
+		managedFields = r.Resource.(*v1.ConfigMap).ManagedFields
 	} else if _, ok := r.Resource.(*v1.Secret); ok {
-		oldSHAData = util.GetSHAfromSecret(r.OldResource.(*v1.Secret).Data)
+		oldSHAData = util.GetSHAfromSecret(r.OldResource.(*v1.Secret))
 		config = util.GetSecretConfig(r.Resource.(*v1.Secret))
+		config.OldData = util.GetSecretData(r.OldResource.(*v1.Secret))
+		config.OldLabels = r.OldResource.(*v1.Secret).Labels
+		config.OldAnnotations = r.OldResource.(*v1.Secret).Annotations
+		managedFields = r.Resource.(*v1.Secret).ManagedFields
 	} else {
 		logrus.Warnf("Invalid resource: Resource should be 'Secret' or 'Configmap' but found, %v", r.Resource)
 	}
+	// The managers of the changed keys made the change
+	config.Managers = util.GetDataManagers(managedFields, util.GetChangedKeys(config.OldData, config.Data))
 	return config, oldSHAData
 }
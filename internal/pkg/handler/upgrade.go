@@ -1,24 +1,33 @@
 package handler
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/condition"
 	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/events"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/selector"
 	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/internal/pkg/workload"
 	"github.com/stakater/Reloader/pkg/kube"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
 )
 
 // GetDeploymentRollingUpgradeFuncs returns all callback funcs for a deployment
 func GetDeploymentRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
 		ItemsFunc:          callbacks.GetDeploymentItems,
+		ItemFunc:           callbacks.GetDeploymentItem,
 		AnnotationsFunc:    callbacks.GetDeploymentAnnotations,
 		PodAnnotationsFunc: callbacks.GetDeploymentPodAnnotations,
 		ContainersFunc:     callbacks.GetDeploymentContainers,
@@ -33,6 +42,7 @@ func GetDeploymentRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 func GetDaemonSetRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
 		ItemsFunc:          callbacks.GetDaemonSetItems,
+		ItemFunc:           callbacks.GetDaemonSetItem,
 		AnnotationsFunc:    callbacks.GetDaemonSetAnnotations,
 		PodAnnotationsFunc: callbacks.GetDaemonSetPodAnnotations,
 		ContainersFunc:     callbacks.GetDaemonSetContainers,
@@ -47,6 +57,7 @@ func GetDaemonSetRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 func GetStatefulSetRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
 		ItemsFunc:          callbacks.GetStatefulSetItems,
+		ItemFunc:           callbacks.GetStatefulSetItem,
 		AnnotationsFunc:    callbacks.GetStatefulSetAnnotations,
 		PodAnnotationsFunc: callbacks.GetStatefulSetPodAnnotations,
 		ContainersFunc:     callbacks.GetStatefulSetContainers,
@@ -61,6 +72,7 @@ func GetStatefulSetRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 func GetDeploymentConfigRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
 		ItemsFunc:          callbacks.GetDeploymentConfigItems,
+		ItemFunc:           callbacks.GetDeploymentConfigItem,
 		AnnotationsFunc:    callbacks.GetDeploymentConfigAnnotations,
 		PodAnnotationsFunc: callbacks.GetDeploymentConfigPodAnnotations,
 		ContainersFunc:     callbacks.GetDeploymentConfigContainers,
@@ -75,6 +87,7 @@ func GetDeploymentConfigRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 func GetArgoRolloutRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
 		ItemsFunc:          callbacks.GetRolloutItems,
+		ItemFunc:           callbacks.GetRolloutItem,
 		AnnotationsFunc:    callbacks.GetRolloutAnnotations,
 		PodAnnotationsFunc: callbacks.GetRolloutPodAnnotations,
 		ContainersFunc:     callbacks.GetRolloutContainers,
@@ -129,63 +142,552 @@ func rollingUpgrade(clients kube.Clients, config util.Config, upgradeFuncs callb
 
 // PerformRollingUpgrade upgrades the deployment if there is any change in configmap or secret data
 func PerformRollingUpgrade(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors) error {
-	items := upgradeFuncs.ItemsFunc(clients, config.Namespace)
+	items := getItems(clients, config, upgradeFuncs)
 
 	for _, i := range items {
-		// find correct annotation and update the resource
-		annotations := upgradeFuncs.AnnotationsFunc(i)
-		annotationValue, found := annotations[config.Annotation]
-		searchAnnotationValue, foundSearchAnn := annotations[options.AutoSearchAnnotation]
-		reloaderEnabledValue, foundAuto := annotations[options.ReloaderAutoAnnotation]
-		if !found && !foundAuto && !foundSearchAnn {
-			annotations = upgradeFuncs.PodAnnotationsFunc(i)
-			annotationValue = annotations[config.Annotation]
-			searchAnnotationValue = annotations[options.AutoSearchAnnotation]
-			reloaderEnabledValue = annotations[options.ReloaderAutoAnnotation]
-		}
-		result := constants.NotUpdated
-		reloaderEnabled, err := strconv.ParseBool(reloaderEnabledValue)
-		if err == nil && reloaderEnabled {
-			result = updateContainers(upgradeFuncs, i, config, true)
-		}
-
-		if result != constants.Updated && annotationValue != "" {
-			values := strings.Split(annotationValue, ",")
-			for _, value := range values {
-				value = strings.Trim(value, " ")
-				if value == config.ResourceName {
-					result = updateContainers(upgradeFuncs, i, config, false)
-					if result == constants.Updated {
-						break
-					}
-				}
-			}
-		}
+		config := config
+		itemNamespace := util.ToObjectMeta(i).Namespace
+		config.CrossNamespace = itemNamespace != config.Namespace
 
-		if result != constants.Updated && searchAnnotationValue == "true" {
-			matchAnnotationValue := config.ResourceAnnotations[options.SearchMatchAnnotation]
-			if matchAnnotationValue == "true" {
-				result = updateContainers(upgradeFuncs, i, config, true)
-			}
+		if config.Deleted && !shouldReloadOnDelete(upgradeFuncs, i, config) {
+			continue
+		}
+		if config.Reconcile && !hasRecordedSHA(upgradeFuncs, i, config) {
+			continue
+		}
+		if !isManagerAllowed(upgradeFuncs, i, config) {
+			continue
 		}
 
+		original := i.(runtime.Object).DeepCopyObject()
+		result, match := reloadItem(upgradeFuncs, i, config)
+
 		if result == constants.Updated {
-			err = upgradeFuncs.UpdateFunc(clients, config.Namespace, i)
+			// The condition is only evaluated for items using the configmap or secret, deletions always reload
+			if !config.Deleted && !isConditionMet(upgradeFuncs, i, config) {
+				continue
+			}
+			if isDryRun(itemNamespace) {
+				reportDryRunReload(config, match, upgradeFuncs, i, collectors)
+				continue
+			}
+
+			err := updateItem(clients, config, upgradeFuncs, original, i)
 			resourceName := util.ToObjectMeta(i).Name
 			if err != nil {
-				logrus.Errorf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, config.Namespace, err)
+				logrus.Errorf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, itemNamespace, err)
 				collectors.Reloaded.With(prometheus.Labels{"success": "false"}).Inc()
 				return err
 			} else {
-				logrus.Infof("Changes detected in '%s' of type '%s' in namespace '%s'", config.ResourceName, config.Type, config.Namespace)
-				logrus.Infof("Updated '%s' of type '%s' in namespace '%s'", resourceName, upgradeFuncs.ResourceType, config.Namespace)
+				if config.Reconcile {
+					logrus.Infof("Missed changes detected in '%s' of type '%s' in namespace '%s'", config.ResourceName, config.Type, config.Namespace)
+				} else {
+					logrus.Infof("Changes detected in '%s' of type '%s' in namespace '%s'%s", config.ResourceName, config.Type, config.Namespace, formatManagers(config))
+				}
+				logrus.Infof("Updated '%s' of type '%s' in namespace '%s' by rule '%s' as it is %s", resourceName, upgradeFuncs.ResourceType, itemNamespace, match.rule, match.reason)
+				events.Record(i.(runtime.Object), v1.EventTypeNormal, events.ReasonReload, "Reloaded by rule '%s' upon changes in %s '%s'%s",
+					match.rule, strings.ToLower(config.Type), config.ResourceName, formatManagers(config))
 				collectors.Reloaded.With(prometheus.Labels{"success": "true"}).Inc()
+				collectors.ReloadedByRule.With(prometheus.Labels{"rule": match.rule}).Inc()
 			}
 		}
 	}
 	return nil
 }
 
+// isDryRun checks whether the workloads in the namespace are only reported instead of being reloaded.
+// The dry-run annotation of the namespace takes precedence over the global dry-run mode, it is read from
+// the cache of namespaces and ignored if namespaces are not cached
+func isDryRun(namespace string) bool {
+	namespaces := selector.GetSharedNamespaces()
+	if namespaces == nil {
+		return options.DryRun
+	}
+	annotations, found := namespaces.Annotations(namespace)
+	if !found {
+		logrus.Warnf("Namespace '%s' is not cached to check annotation '%s', falling back to dry-run mode '%t'", namespace, options.DryRunAnnotation, options.DryRun)
+		return options.DryRun
+	}
+
+	value, found := annotations[options.DryRunAnnotation]
+	if !found {
+		return options.DryRun
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		logrus.Warnf("Invalid value '%s' for annotation '%s' on namespace '%s', falling back to dry-run mode '%t'", value, options.DryRunAnnotation, namespace, options.DryRun)
+		return options.DryRun
+	}
+	return dryRun
+}
+
+// reportDryRunReload logs, counts and records an event for the item which would have been reloaded
+func reportDryRunReload(config util.Config, match ruleMatch, upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, collectors metrics.Collectors) {
+	meta := util.ToObjectMeta(item)
+	logrus.WithFields(logrus.Fields{
+		"workload":          meta.Name,
+		"workloadType":      upgradeFuncs.ResourceType,
+		"namespace":         meta.Namespace,
+		"resource":          config.ResourceName,
+		"resourceType":      config.Type,
+		"resourceNamespace": config.Namespace,
+		"sha":               config.SHAValue,
+		"rule":              match.rule,
+		"reason":            match.reason,
+		"managers":          strings.Join(config.Managers, ","),
+	}).Infof("Would reload '%s' of type '%s' in namespace '%s' (dry-run)", meta.Name, upgradeFuncs.ResourceType, meta.Namespace)
+	collectors.DryRunReloaded.Inc()
+	events.Record(item.(runtime.Object), v1.EventTypeNormal, events.ReasonDryRunReload, "Would reload by rule '%s' upon changes in %s '%s'%s (dry-run)",
+		match.rule, strings.ToLower(config.Type), config.ResourceName, formatManagers(config))
+}
+
+// getItems returns the workloads which may be reloaded by the configmap or secret. They are resolved
+// from the shared workload cache if available, otherwise every workload in the namespace is listed, or
+// in all namespaces if the configmap or secret may be referenced from other namespaces
+func getItems(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs) []interface{} {
+	if workloadCache := workload.GetSharedCache(); workloadCache != nil {
+		if items, ok := workloadCache.ItemsReferencing(upgradeFuncs.ResourceType, config); ok {
+			return appendReloadTargets(clients, config, upgradeFuncs, items, workloadCache)
+		}
+	}
+	if isCrossNamespaceSource(config.Namespace) {
+		return upgradeFuncs.ItemsFunc(clients, v1.NamespaceAll)
+	}
+	return upgradeFuncs.ItemsFunc(clients, config.Namespace)
+}
+
+// appendReloadTargets appends the workloads listed in the reload targets annotation of the configmap or secret, which
+// are not indexed as they do not reference it. They are resolved from the workload cache if set, otherwise from the API server
+func appendReloadTargets(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, items []interface{}, workloadCache *workload.Cache) []interface{} {
+	for _, target := range getReloadTargets(config, upgradeFuncs) {
+		found := false
+		for _, i := range items {
+			meta := util.ToObjectMeta(i)
+			if meta.Namespace == config.Namespace && meta.Name == target.Name {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		if workloadCache != nil {
+			item, found := workloadCache.Item(upgradeFuncs.ResourceType, config.Namespace, target.Name)
+			if !found {
+				logrus.Warnf("Reload target '%s' of type '%s' in namespace '%s' not found in cache", target.Name, upgradeFuncs.ResourceType, config.Namespace)
+				continue
+			}
+			items = append(items, item)
+			continue
+		}
+		item, err := upgradeFuncs.ItemFunc(clients, target.Name, config.Namespace)
+		if err != nil {
+			logrus.Warnf("Failed to get reload target '%s' of type '%s' in namespace '%s': %v", target.Name, upgradeFuncs.ResourceType, config.Namespace, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// getReloadTargets returns the workloads of the type listed in the reload targets annotation of the configmap or secret
+func getReloadTargets(config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs) []util.WorkloadReference {
+	targets := []util.WorkloadReference{}
+	for _, target := range util.ParseWorkloadReferences(config.ResourceAnnotations[options.ReloadTargetsAnnotation]) {
+		if target.IsKind(upgradeFuncs.ResourceType) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// isReloadTarget checks whether the item is listed in the reload targets annotation of the configmap or secret
+func isReloadTarget(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	if config.CrossNamespace {
+		return false
+	}
+	name := util.ToObjectMeta(item).Name
+	for _, target := range getReloadTargets(config, upgradeFuncs) {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isCrossNamespaceSource checks whether the configmaps and secrets of the namespace may be referenced by workloads in
+// other namespaces
+func isCrossNamespaceSource(namespace string) bool {
+	allowedNamespaces := util.List(options.AllowedCrossNamespaceSources)
+	return allowedNamespaces.Contains(namespace)
+}
+
+// isExcluded checks whether the configmap or secret is named in the exclude annotation of the item, which removes
+// it from the automatic reload. Globs and regular expressions are supported as in the reload annotations
+func isExcluded(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, excludeAnnotationValue string, config util.Config) bool {
+	for _, reference := range util.ParseResourceReferences(excludeAnnotationValue) {
+		matches, err := reference.Matches(config.ResourceName)
+		if err != nil {
+			reportInvalidPattern(upgradeFuncs, item, reference.Name, err)
+			continue
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// referencesNamespace checks whether the reference is in the namespace of the configmap or secret. References without
+// a namespace are in the namespace of the item, references to other namespaces must be allowed
+func referencesNamespace(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, reference util.ResourceReference, config util.Config) bool {
+	if reference.Namespace == "" {
+		return !config.CrossNamespace
+	}
+	if reference.Namespace != config.Namespace {
+		return false
+	}
+	if config.CrossNamespace && !isCrossNamespaceSource(config.Namespace) {
+		meta := util.ToObjectMeta(item)
+		logrus.Debugf("Ignoring reference to '%s/%s' of '%s' of type '%s' in namespace '%s' as namespace '%s' is not allowed as source of cross-namespace references",
+			reference.Namespace, reference.Name, meta.Name, upgradeFuncs.ResourceType, meta.Namespace, config.Namespace)
+		return false
+	}
+	return true
+}
+
+// reportedErrors holds the invalid patterns, selectors and conditions in annotations which have been reported, by workload
+var reportedErrors sync.Map
+
+// reportInvalidPattern logs and records an event for an invalid pattern in the reload annotation of the item,
+// once per item and pattern
+func reportInvalidPattern(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, pattern string, err error) {
+	reportInvalidAnnotation(upgradeFuncs, item, events.ReasonInvalidPattern, "pattern", pattern, err)
+}
+
+// reportInvalidSelector logs and records an event for an invalid label selector in the selector annotation of the
+// item, once per item and selector
+func reportInvalidSelector(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, selector string, err error) {
+	reportInvalidAnnotation(upgradeFuncs, item, events.ReasonInvalidSelector, "selector", selector, err)
+}
+
+// reportInvalidCondition logs and records an event for an invalid condition annotation of the item, once per item
+// and condition
+func reportInvalidCondition(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, expression string, err error) {
+	reportInvalidAnnotation(upgradeFuncs, item, events.ReasonInvalidCondition, "condition", expression, err)
+}
+
+func reportInvalidAnnotation(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, reason string, kind string, value string, err error) {
+	meta := util.ToObjectMeta(item)
+	key := upgradeFuncs.ResourceType + "/" + meta.Namespace + "/" + meta.Name + "/" + kind + "/" + value
+	if _, reported := reportedErrors.LoadOrStore(key, true); reported {
+		return
+	}
+	logrus.Errorf("Invalid %s '%s' in annotation of '%s' of type '%s' in namespace '%s': %v", kind, value, meta.Name, upgradeFuncs.ResourceType, meta.Namespace, err)
+	events.Record(item.(runtime.Object), v1.EventTypeWarning, reason, "Invalid %s '%s' in annotation: %v", kind, value, err)
+}
+
+// isManagerAllowed checks whether the change of the configmap or secret was made by a field manager allowed to reload
+// the item. The annotations of the item override the managers of the flags, changes of unknown managers are allowed
+func isManagerAllowed(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	if len(config.Managers) == 0 {
+		return true
+	}
+
+	allowed := options.ReloadOnManagers
+	if value, found := getAnnotation(upgradeFuncs, item, options.ReloadOnManagersAnnotation); found {
+		allowed = util.ParseManagers(value)
+	}
+	ignored := options.IgnoredManagers
+	if value, found := getAnnotation(upgradeFuncs, item, options.IgnoreManagersAnnotation); found {
+		ignored = util.ParseManagers(value)
+	}
+
+	if util.AnyManagerAllowed(config.Managers, allowed, ignored) {
+		return true
+	}
+	meta := util.ToObjectMeta(item)
+	logrus.Debugf("Ignoring changes in '%s' of type '%s' in namespace '%s' by '%s' for '%s' of type '%s' in namespace '%s'", config.ResourceName, config.Type,
+		config.Namespace, strings.Join(config.Managers, ","), meta.Name, upgradeFuncs.ResourceType, meta.Namespace)
+	return false
+}
+
+// formatManagers returns the field managers which changed the configmap or secret for log messages and events
+func formatManagers(config util.Config) string {
+	if len(config.Managers) == 0 {
+		return ""
+	}
+	return " by '" + strings.Join(config.Managers, "', '") + "'"
+}
+
+// isConditionMet evaluates the condition annotation of the item against the old and new state of the configmap or
+// secret. Items without a condition always meet it, invalid conditions and failed evaluations never do. Failed
+// evaluations are reported like invalid conditions, once per item and condition. Without the old state, i.e. upon
+// creation or reconciliation of the configmap or secret, a valid condition is met without evaluating it
+func isConditionMet(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	expression, _ := getAnnotation(upgradeFuncs, item, options.ReloadConditionAnnotation)
+	if strings.TrimSpace(expression) == "" {
+		return true
+	}
+
+	reloadCondition, err := condition.Compile(expression)
+	if err != nil {
+		reportInvalidCondition(upgradeFuncs, item, expression, err)
+		return false
+	}
+	if config.Reconcile || config.OldData == nil {
+		return true
+	}
+	met, err := reloadCondition.Evaluate(
+		condition.Resource{Data: config.OldData, Labels: config.OldLabels, Annotations: config.OldAnnotations},
+		condition.Resource{Data: config.Data, Labels: config.ResourceLabels, Annotations: config.ResourceAnnotations},
+	)
+	if err != nil {
+		reportInvalidCondition(upgradeFuncs, item, expression, err)
+		return false
+	}
+	if !met {
+		meta := util.ToObjectMeta(item)
+		logrus.Debugf("Condition '%s' of '%s' of type '%s' in namespace '%s' is not met", expression, meta.Name, upgradeFuncs.ResourceType, meta.Namespace)
+	}
+	return met
+}
+
+// keysChanged checks whether any of the keys changed in an update of the configmap or secret. Without keys, or
+// if the previous data is unknown, any change is relevant
+func keysChanged(config util.Config, keys []string) bool {
+	if len(keys) == 0 || config.Deleted || config.OldData == nil {
+		return true
+	}
+	return util.GetSHAfromKeys(config.OldData, keys) != util.GetSHAfromKeys(config.Data, keys)
+}
+
+// getKeysConfig returns the config with the SHA of the given keys of the configmap or secret, so that the
+// workload records only the state of these keys
+func getKeysConfig(config util.Config, keys []string) util.Config {
+	if len(keys) == 0 || config.Deleted {
+		return config
+	}
+	config.SHAValue = util.GetSHAfromKeys(config.Data, keys)
+	return config
+}
+
+// getPodTemplateKeys returns the keys of the configmap or secret referenced by the pod template of the item with
+// configMapKeyRef or secretKeyRef env vars and volume items. It returns nil if AutoReloadReferencedKeys is disabled or
+// the pod template consumes the whole configmap or secret somewhere, e.g. with envFrom or a volume without items
+func getPodTemplateKeys(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) []string {
+	if !options.AutoReloadReferencedKeys {
+		return nil
+	}
+
+	keys := []string{}
+	addItemKeys := func(items []v1.KeyToPath) bool {
+		if len(items) == 0 {
+			return false
+		}
+		for _, keyToPath := range items {
+			keys = append(keys, keyToPath.Key)
+		}
+		return true
+	}
+
+	for _, volume := range upgradeFuncs.VolumesFunc(item) {
+		if config.Type == constants.ConfigmapEnvVarPostfix {
+			if volume.ConfigMap != nil && volume.ConfigMap.Name == config.ResourceName && !addItemKeys(volume.ConfigMap.Items) {
+				return nil
+			}
+		} else if volume.Secret != nil && volume.Secret.SecretName == config.ResourceName && !addItemKeys(volume.Secret.Items) {
+			return nil
+		}
+
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if config.Type == constants.ConfigmapEnvVarPostfix {
+					if source.ConfigMap != nil && source.ConfigMap.Name == config.ResourceName && !addItemKeys(source.ConfigMap.Items) {
+						return nil
+					}
+				} else if source.Secret != nil && source.Secret.Name == config.ResourceName && !addItemKeys(source.Secret.Items) {
+					return nil
+				}
+			}
+		}
+	}
+
+	containers := append([]v1.Container{}, upgradeFuncs.ContainersFunc(item)...)
+	containers = append(containers, upgradeFuncs.InitContainersFunc(item)...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if config.Type == constants.ConfigmapEnvVarPostfix {
+				if env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == config.ResourceName {
+					keys = append(keys, env.ValueFrom.ConfigMapKeyRef.Key)
+				}
+			} else if env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == config.ResourceName {
+				keys = append(keys, env.ValueFrom.SecretKeyRef.Key)
+			}
+		}
+
+		for _, envFrom := range container.EnvFrom {
+			if config.Type == constants.ConfigmapEnvVarPostfix {
+				if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == config.ResourceName {
+					return nil
+				}
+			} else if envFrom.SecretRef != nil && envFrom.SecretRef.Name == config.ResourceName {
+				return nil
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys
+}
+
+// updateItem patches the changes of the reload on the item. On a conflict the item is read again
+// and the reload is recorded once more on the latest version before retrying
+func updateItem(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, original interface{}, modified interface{}) error {
+	resourceName := util.ToObjectMeta(modified).Name
+	namespace := util.ToObjectMeta(modified).Namespace
+	firstAttempt := true
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !firstAttempt {
+			logrus.Infof("Conflict while updating '%s' of type '%s' in namespace '%s', retrying", resourceName, upgradeFuncs.ResourceType, namespace)
+			item, err := upgradeFuncs.ItemFunc(clients, resourceName, namespace)
+			if err != nil {
+				return err
+			}
+			original = item.(runtime.Object).DeepCopyObject()
+			modified = item
+			if result, _ := reloadItem(upgradeFuncs, modified, config); result != constants.Updated {
+				// The latest version already carries the SHA of the configmap or secret
+				return nil
+			}
+		}
+		firstAttempt = false
+		return upgradeFuncs.UpdateFunc(clients, namespace, original, modified)
+	})
+}
+
+// hasRecordedSHA checks whether the item recorded a SHA of the configmap or secret. Items which never recorded a SHA
+// are not reconciled, as it is unknown which version of the configmap or secret they use
+func hasRecordedSHA(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	return getRecordedSHA(upgradeFuncs, item, config) != ""
+}
+
+// getRecordedSHA returns the SHA of the configmap or secret recorded on the item by its reload strategy
+func getRecordedSHA(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) string {
+	if getReloadStrategy(upgradeFuncs, item) == constants.AnnotationsReloadStrategy {
+		return getReloadedHashes(upgradeFuncs, item)[getReloadedHashKey(config)]
+	}
+
+	envVar := getEnvVarName(config)
+	for _, container := range upgradeFuncs.ContainersFunc(item) {
+		for _, env := range container.Env {
+			if env.Name == envVar {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// shouldReloadOnDelete checks the on-delete annotation of the workload, falling back to its pod annotations,
+// to decide whether the deletion of the configmap or secret should reload it
+func shouldReloadOnDelete(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	onDeleteValue, found := upgradeFuncs.AnnotationsFunc(item)[options.ReloadOnDeleteAnnotation]
+	if !found {
+		onDeleteValue, found = upgradeFuncs.PodAnnotationsFunc(item)[options.ReloadOnDeleteAnnotation]
+	}
+	if !found {
+		onDeleteValue = constants.OptionalOnDelete
+	}
+
+	switch strings.TrimSpace(onDeleteValue) {
+	case constants.ReloadOnDelete:
+		return true
+	case constants.IgnoreOnDelete:
+		return false
+	case constants.OptionalOnDelete:
+		return isOptionalReference(upgradeFuncs, item, config)
+	default:
+		logrus.Warnf("Invalid value '%s' for annotation '%s' on '%s', expected '%s', '%s' or '%s'", onDeleteValue, options.ReloadOnDeleteAnnotation,
+			util.ToObjectMeta(item).Name, constants.ReloadOnDelete, constants.IgnoreOnDelete, constants.OptionalOnDelete)
+		return false
+	}
+}
+
+// isOptionalReference checks whether the configmap or secret is referenced as optional in a volume or env var of the workload
+func isOptionalReference(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) bool {
+	for _, volume := range upgradeFuncs.VolumesFunc(item) {
+		if config.Type == constants.ConfigmapEnvVarPostfix {
+			if volume.ConfigMap != nil && volume.ConfigMap.Name == config.ResourceName {
+				return isOptional(volume.ConfigMap.Optional)
+			}
+		} else if volume.Secret != nil && volume.Secret.SecretName == config.ResourceName {
+			return isOptional(volume.Secret.Optional)
+		}
+
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if config.Type == constants.ConfigmapEnvVarPostfix {
+					if source.ConfigMap != nil && source.ConfigMap.Name == config.ResourceName {
+						return isOptional(source.ConfigMap.Optional)
+					}
+				} else if source.Secret != nil && source.Secret.Name == config.ResourceName {
+					return isOptional(source.Secret.Optional)
+				}
+			}
+		}
+	}
+
+	for _, container := range upgradeFuncs.ContainersFunc(item) {
+		if found, optional := isOptionalEnvReference(container, config); found {
+			return optional
+		}
+	}
+	for _, container := range upgradeFuncs.InitContainersFunc(item) {
+		if found, optional := isOptionalEnvReference(container, config); found {
+			return optional
+		}
+	}
+	return false
+}
+
+// isOptionalEnvReference checks whether the container references the configmap or secret in its env vars and if so, whether as optional
+func isOptionalEnvReference(container v1.Container, config util.Config) (bool, bool) {
+	for _, env := range container.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if config.Type == constants.ConfigmapEnvVarPostfix {
+			if env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == config.ResourceName {
+				return true, isOptional(env.ValueFrom.ConfigMapKeyRef.Optional)
+			}
+		} else if env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == config.ResourceName {
+			return true, isOptional(env.ValueFrom.SecretKeyRef.Optional)
+		}
+	}
+
+	for _, envFrom := range container.EnvFrom {
+		if config.Type == constants.ConfigmapEnvVarPostfix {
+			if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == config.ResourceName {
+				return true, isOptional(envFrom.ConfigMapRef.Optional)
+			}
+		} else if envFrom.SecretRef != nil && envFrom.SecretRef.Name == config.ResourceName {
+			return true, isOptional(envFrom.SecretRef.Optional)
+		}
+	}
+	return false, false
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
 func getVolumeMountName(volumes []v1.Volume, mountType string, volumeName string) string {
 	for i := range volumes {
 		if mountType == constants.ConfigmapEnvVarPostfix {
@@ -296,9 +798,108 @@ func getContainerToUpdate(upgradeFuncs callbacks.RollingUpgradeFuncs, item inter
 	return container
 }
 
+// invokeReloadStrategy records the SHA of the configmap or secret on the item with the reload strategy
+// defined by the item's annotations, falling back to the globally configured one
+func invokeReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config, autoReload bool) constants.Result {
+	if getReloadStrategy(upgradeFuncs, item) == constants.AnnotationsReloadStrategy {
+		return updatePodAnnotations(upgradeFuncs, item, config, autoReload)
+	}
+	return updateContainers(upgradeFuncs, item, config, autoReload)
+}
+
+// invokeAutoReloadStrategy invokes the reload strategy for an automatically reloaded item, restricted to the
+// keys referenced by its pod template if any
+func invokeAutoReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config) constants.Result {
+	keys := getPodTemplateKeys(upgradeFuncs, item, config)
+	if !keysChanged(config, keys) {
+		return constants.NotUpdated
+	}
+	return invokeReloadStrategy(upgradeFuncs, item, getKeysConfig(config, keys), true)
+}
+
+func getReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}) string {
+	strategy, found := upgradeFuncs.AnnotationsFunc(item)[options.ReloadStrategyAnnotation]
+	if !found {
+		strategy, found = upgradeFuncs.PodAnnotationsFunc(item)[options.ReloadStrategyAnnotation]
+	}
+	if !found {
+		return options.ReloadStrategy
+	}
+
+	strategy = strings.TrimSpace(strategy)
+	if strategy != constants.EnvVarsReloadStrategy && strategy != constants.AnnotationsReloadStrategy {
+		logrus.Warnf("Invalid value '%s' for annotation '%s' on '%s', falling back to reload strategy '%s'", strategy, options.ReloadStrategyAnnotation,
+			util.ToObjectMeta(item).Name, options.ReloadStrategy)
+		return options.ReloadStrategy
+	}
+	return strategy
+}
+
+// getReloadedHashKey returns the key of the configmap or secret in the ReloadedHashesAnnotation
+func getReloadedHashKey(config util.Config) string {
+	return strings.ToLower(config.Type) + "/" + getRecordedName(config)
+}
+
+// getRecordedName returns the name under which the SHA of the configmap or secret is recorded, which includes its
+// namespace if it is in another namespace than the workload
+func getRecordedName(config util.Config) string {
+	if config.CrossNamespace {
+		return config.Namespace + "/" + config.ResourceName
+	}
+	return config.ResourceName
+}
+
+// getReloadedHashes returns the SHAs recorded in the ReloadedHashesAnnotation of the item's pod template
+func getReloadedHashes(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}) map[string]string {
+	hashes := map[string]string{}
+	if value, found := upgradeFuncs.PodAnnotationsFunc(item)[constants.ReloadedHashesAnnotation]; found {
+		if err := json.Unmarshal([]byte(value), &hashes); err != nil {
+			logrus.Warnf("Ignoring malformed annotation '%s' on '%s': %v", constants.ReloadedHashesAnnotation, util.ToObjectMeta(item).Name, err)
+			return map[string]string{}
+		}
+	}
+	return hashes
+}
+
+func updatePodAnnotations(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config, autoReload bool) constants.Result {
+	container := getContainerToUpdate(upgradeFuncs, item, config, autoReload)
+
+	if container == nil {
+		return constants.NoContainerFound
+	}
+
+	podAnnotations := upgradeFuncs.PodAnnotationsFunc(item)
+	hashes := getReloadedHashes(upgradeFuncs, item)
+	key := getReloadedHashKey(config)
+	if hashes[key] == config.SHAValue {
+		return constants.NotUpdated
+	}
+	hashes[key] = config.SHAValue
+
+	hashesValue, err := json.Marshal(hashes)
+	if err != nil {
+		logrus.Errorf("Failed to marshal annotation '%s': %v", constants.ReloadedHashesAnnotation, err)
+		return constants.NotUpdated
+	}
+	sourceValue, err := json.Marshal(util.NewReloadSourceFromConfig(config, []string{container.Name}))
+	if err != nil {
+		logrus.Errorf("Failed to marshal annotation '%s': %v", constants.LastReloadedFromAnnotation, err)
+		return constants.NotUpdated
+	}
+
+	podAnnotations[constants.ReloadedHashesAnnotation] = string(hashesValue)
+	podAnnotations[constants.LastReloadedFromAnnotation] = string(sourceValue)
+	return constants.Updated
+}
+
+// getEnvVarName returns the name of the env var recording the SHA of the configmap or secret
+func getEnvVarName(config util.Config) string {
+	return constants.EnvVarPrefix + util.ConvertToEnvVarName(getRecordedName(config)) + "_" + config.Type
+}
+
 func updateContainers(upgradeFuncs callbacks.RollingUpgradeFuncs, item interface{}, config util.Config, autoReload bool) constants.Result {
 	var result constants.Result
-	envVar := constants.EnvVarPrefix + util.ConvertToEnvVarName(config.ResourceName) + "_" + config.Type
+	envVar := getEnvVarName(config)
 	container := getContainerToUpdate(upgradeFuncs, item, config, autoReload)
 
 	if container == nil {
@@ -2,22 +2,38 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
 	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/events"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/selector"
 	"github.com/stakater/Reloader/internal/pkg/testutil"
 	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/internal/pkg/workload"
 	"github.com/stakater/Reloader/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -1282,7 +1298,7 @@ func TestFailedRollingUpgrade(t *testing.T) {
 	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, configmapName, "fail.stakater.com")
 	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, configmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
 	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
-	deploymentFuncs.UpdateFunc = func(_ kube.Clients, _ string, _ interface{}) error {
+	deploymentFuncs.UpdateFunc = func(_ kube.Clients, _ string, _ interface{}, _ interface{}) error {
 		return fmt.Errorf("error")
 	}
 	collectors := getCollectors()
@@ -1293,3 +1309,1358 @@ func TestFailedRollingUpgrade(t *testing.T) {
 		t.Errorf("Counter was not increased")
 	}
 }
+
+func TestRollingUpgradeForDeploymentWithDeletedConfigmap(t *testing.T) {
+	deletedConfigmapName := "testdeletedconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		deletedConfigmapName,
+		namespace,
+		map[string]string{
+			options.ReloaderAutoAnnotation:   "true",
+			options.ReloadOnDeleteAnnotation: constants.ReloadOnDelete,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with on-delete annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, deletedConfigmapName, util.GetSHAfromDeletedResource(), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Deleted = true
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with deleted Configmap")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithDeletedConfigmapNotOptional(t *testing.T) {
+	deletedConfigmapName := "testdeletedconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		deletedConfigmapName,
+		namespace,
+		map[string]string{options.ReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, deletedConfigmapName, util.GetSHAfromDeletedResource(), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Deleted = true
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with deleted Configmap")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if updated {
+		t.Errorf("Deployment was updated unexpectedly")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Counter was increased unexpectedly")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapUsingAnnotationsStrategy(t *testing.T) {
+	strategyConfigmapName := "testannotationsstrategy-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		strategyConfigmapName,
+		namespace,
+		map[string]string{
+			options.ReloaderAutoAnnotation:   "true",
+			options.ReloadStrategyAnnotation: constants.AnnotationsReloadStrategy,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with reload strategy annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, strategyConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, strategyConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with annotations reload strategy")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updatedDeployment, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	podAnnotations := updatedDeployment.Spec.Template.Annotations
+	if testutil.GetResourceSHAFromAnnotation(podAnnotations, config.Type, config.ResourceName) != shaData {
+		t.Errorf("Deployment pod annotations were not updated")
+	}
+	if podAnnotations[constants.LastReloadedFromAnnotation] == "" {
+		t.Errorf("Deployment pod annotation '%s' was not set", constants.LastReloadedFromAnnotation)
+	}
+	envName := constants.EnvVarPrefix + util.ConvertToEnvVarName(config.ResourceName) + "_" + constants.ConfigmapEnvVarPostfix
+	if testutil.GetResourceSHA(updatedDeployment.Spec.Template.Spec.Containers, envName) != "" {
+		t.Errorf("Deployment container env var was updated unexpectedly")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+
+	// Reloading with the same SHA must not update the deployment again
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with annotations reload strategy")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was increased unexpectedly")
+	}
+}
+
+func TestRollingUpgradeForDeploymentRetriesOnConflict(t *testing.T) {
+	conflictConfigmapName := "testconflictconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		conflictConfigmapName,
+		namespace,
+		map[string]string{options.ReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, conflictConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conflictConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	updateAttempts := 0
+	deploymentFuncs.UpdateFunc = func(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+		updateAttempts++
+		if updateAttempts == 1 {
+			return apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, deployment.Name, fmt.Errorf("conflict"))
+		}
+		return callbacks.UpdateDeployment(clients, namespace, original, modified)
+	}
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment after a conflict")
+	}
+	if updateAttempts != 2 {
+		t.Errorf("Update was attempted %d times, want 2", updateAttempts)
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+// enforceResourceVersion makes the fake clientset behave like the API server for patches of deployments. A patch
+// holding an outdated resourceVersion fails with a conflict, and each patch increments the resourceVersion
+func enforceResourceVersion(client *testclient.Clientset) {
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+		obj, err := client.Tracker().Get(gvr, patchAction.GetNamespace(), patchAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		deployment := obj.(*appsv1.Deployment)
+
+		precondition := struct {
+			Metadata struct {
+				ResourceVersion *string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(patchAction.GetPatch(), &precondition); err != nil {
+			return true, nil, err
+		}
+		if precondition.Metadata.ResourceVersion != nil && *precondition.Metadata.ResourceVersion != deployment.ResourceVersion {
+			return true, nil, apierrors.NewConflict(gvr.GroupResource(), deployment.Name, fmt.Errorf("the object has been modified"))
+		}
+
+		original, err := json.Marshal(deployment)
+		if err != nil {
+			return true, nil, err
+		}
+		patched, err := strategicpatch.StrategicMergePatch(original, patchAction.GetPatch(), appsv1.Deployment{})
+		if err != nil {
+			return true, nil, err
+		}
+		updated := &appsv1.Deployment{}
+		if err := json.Unmarshal(patched, updated); err != nil {
+			return true, nil, err
+		}
+		resourceVersion, _ := strconv.Atoi(deployment.ResourceVersion)
+		updated.ResourceVersion = strconv.Itoa(resourceVersion + 1)
+		return true, updated, client.Tracker().Update(gvr, updated, patchAction.GetNamespace())
+	})
+}
+
+func TestRollingUpgradeForDeploymentKeepsConcurrentlyRecordedHashes(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	enforceResourceVersion(client)
+	concurrentClients := kube.Clients{KubernetesClient: client}
+
+	firstConfigmapName := "testconcurrentconfigmap-handler-" + testutil.RandSeq(5)
+	secondConfigmapName := "testconcurrentconfigmap-handler-" + testutil.RandSeq(5)
+	deployment := testutil.GetDeploymentWithEnvVarSources(namespace, firstConfigmapName)
+	deployment.ResourceVersion = "1"
+	deployment.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: firstConfigmapName + "," + secondConfigmapName,
+		options.ReloadStrategyAnnotation:          constants.AnnotationsReloadStrategy,
+	}
+	_, err := client.AppsV1().Deployments(namespace).Create(context.TODO(), deployment, v1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+
+	firstSHA := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, firstConfigmapName, "www.stakater.com")
+	firstConfig := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, firstConfigmapName, firstSHA, options.ConfigmapUpdateOnChangeAnnotation)
+	secondSHA := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, secondConfigmapName, "www.stakater.com")
+	secondConfig := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, secondConfigmapName, secondSHA, options.ConfigmapUpdateOnChangeAnnotation)
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	updateAttempts := 0
+	deploymentFuncs.UpdateFunc = func(clients kube.Clients, namespace string, original interface{}, modified interface{}) error {
+		updateAttempts++
+		if updateAttempts == 1 {
+			// The second configmap is reloaded concurrently after the deployment has been read for the first one
+			if err := PerformRollingUpgrade(clients, secondConfig, GetDeploymentRollingUpgradeFuncs(), getCollectors()); err != nil {
+				t.Errorf("Concurrent rolling upgrade failed for Deployment: %v", err)
+			}
+		}
+		return callbacks.UpdateDeployment(clients, namespace, original, modified)
+	}
+
+	err = PerformRollingUpgrade(concurrentClients, firstConfig, deploymentFuncs, getCollectors())
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment: %v", err)
+	}
+	if updateAttempts != 2 {
+		t.Errorf("Update was attempted %d times, want 2", updateAttempts)
+	}
+
+	updatedDeployment, err := client.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	podAnnotations := updatedDeployment.Spec.Template.Annotations
+	if testutil.GetResourceSHAFromAnnotation(podAnnotations, firstConfig.Type, firstConfigmapName) != firstSHA {
+		t.Errorf("Hash of configmap '%s' was not recorded", firstConfigmapName)
+	}
+	if testutil.GetResourceSHAFromAnnotation(podAnnotations, secondConfig.Type, secondConfigmapName) != secondSHA {
+		t.Errorf("Hash of concurrently reloaded configmap '%s' was dropped", secondConfigmapName)
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithMissedConfigmapChange(t *testing.T) {
+	missedConfigmapName := "testmissedconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		missedConfigmapName,
+		namespace,
+		map[string]string{options.ReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	// Record the SHA of the configmap as if it was reloaded before Reloader went down
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, missedConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, missedConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, getCollectors())
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap")
+	}
+
+	shaData = testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, missedConfigmapName, "www.stakater.com/missed")
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, missedConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.Reconcile = true
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Reconciliation failed for Deployment with missed Configmap change")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithoutRecordedConfigmapSHAIsNotReconciled(t *testing.T) {
+	unrecordedConfigmapName := "testunrecordedconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		unrecordedConfigmapName,
+		namespace,
+		map[string]string{options.ReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, unrecordedConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, unrecordedConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.Reconcile = true
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Reconciliation failed for Deployment with Configmap")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if updated {
+		t.Errorf("Deployment was updated unexpectedly")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Counter was increased unexpectedly")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapInDryRunMode(t *testing.T) {
+	options.DryRun = true
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer func() {
+		options.DryRun = false
+		events.SetRecorder(nil)
+	}()
+
+	dryRunConfigmapName := "testdryrunconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		dryRunConfigmapName,
+		namespace,
+		map[string]string{options.ReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, dryRunConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, dryRunConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap in dry-run mode")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if updated {
+		t.Errorf("Deployment was updated in dry-run mode")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Counter was increased in dry-run mode")
+	}
+	if promtestutil.ToFloat64(collectors.DryRunReloaded) != 1 {
+		t.Errorf("Dry-run counter was not increased")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, events.ReasonDryRunReload) {
+			t.Errorf("Unexpected event '%s', want reason '%s'", event, events.ReasonDryRunReload)
+		}
+	default:
+		t.Errorf("No event was recorded in dry-run mode")
+	}
+}
+
+func TestIsDryRunWithNamespaceAnnotation(t *testing.T) {
+	client := testclient.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "dry-run", Annotations: map[string]string{options.DryRunAnnotation: "true"}}},
+		&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "no-dry-run", Annotations: map[string]string{options.DryRunAnnotation: "false"}}},
+		&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default"}},
+	)
+	namespaces, err := selector.NewNamespaceSelector(client, "")
+	if err != nil {
+		t.Fatalf("NewNamespaceSelector() failed: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if !namespaces.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	// Without cached namespaces, only the global dry-run mode applies
+	if isDryRun("dry-run") {
+		t.Errorf("isDryRun() = true without cached namespaces, want false")
+	}
+
+	selector.SetSharedNamespaces(namespaces)
+	options.DryRun = true
+	defer func() {
+		selector.SetSharedNamespaces(nil)
+		options.DryRun = false
+	}()
+	tests := map[string]bool{"dry-run": true, "no-dry-run": false, "default": true, "unknown": true}
+	for namespace, want := range tests {
+		if got := isDryRun(namespace); got != want {
+			t.Errorf("isDryRun() = %t for namespace '%s', want %t", got, namespace, want)
+		}
+	}
+}
+
+func TestIsManagerAllowed(t *testing.T) {
+	options.ReloadOnManagers = []string{"argocd-controller"}
+	options.IgnoredManagers = []string{"kubectl-*"}
+	defer func() {
+		options.ReloadOnManagers = []string{}
+		options.IgnoredManagers = []string{}
+	}()
+
+	tests := []struct {
+		name        string
+		managers    []string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "TestUnknownManagerShouldBeAllowed", managers: nil, want: true},
+		{name: "TestManagerAllowedByFlagShouldBeAllowed", managers: []string{"argocd-controller"}, want: true},
+		{name: "TestManagerNotAllowedByFlagShouldNotBeAllowed", managers: []string{"external-secrets"}, want: false},
+		{name: "TestManagerIgnoredByFlagShouldNotBeAllowed", managers: []string{"kubectl-edit"}, want: false},
+		{
+			name:        "TestAllowAnnotationShouldOverrideFlag",
+			managers:    []string{"external-secrets"},
+			annotations: map[string]string{options.ReloadOnManagersAnnotation: "external-secrets"},
+			want:        true,
+		},
+		{
+			name:        "TestEmptyAllowAnnotationShouldAllowAllManagers",
+			managers:    []string{"external-secrets"},
+			annotations: map[string]string{options.ReloadOnManagersAnnotation: ""},
+			want:        true,
+		},
+		{
+			name:        "TestIgnoreAnnotationShouldOverrideFlag",
+			managers:    []string{"kubectl-edit"},
+			annotations: map[string]string{options.ReloadOnManagersAnnotation: "", options.IgnoreManagersAnnotation: ""},
+			want:        true,
+		},
+		{
+			name:        "TestIgnoreAnnotationShouldWinOverAllowAnnotation",
+			managers:    []string{"argocd-controller"},
+			annotations: map[string]string{options.IgnoreManagersAnnotation: "argocd-*"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := testutil.GetDeployment(namespace, "testmanagers")
+			deployment.Annotations = tt.annotations
+			config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, "testmanagers", "sha", options.ConfigmapUpdateOnChangeAnnotation)
+			config.Managers = tt.managers
+			if got := isManagerAllowed(GetDeploymentRollingUpgradeFuncs(), deployment, config); got != tt.want {
+				t.Errorf("isManagerAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapKeys(t *testing.T) {
+	keysConfigmapName := "testkeysconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		keysConfigmapName,
+		namespace,
+		map[string]string{options.ConfigmapUpdateOnChangeAnnotation: keysConfigmapName + "[url]"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with configmap annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	oldData := map[string]string{"url": "www.stakater.com", "other": "value"}
+
+	// A change of another key must not reload the deployment
+	otherData := map[string]string{"url": "www.stakater.com", "other": "changed"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, keysConfigmapName, util.GetSHAfromData(otherData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = otherData
+	config.OldData = oldData
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap keys")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded upon a change of a key it does not watch")
+	}
+
+	// A change of a watched key reloads the deployment with the SHA of the watched keys only
+	urlData := map[string]string{"url": "www.stakater.com/changed", "other": "value"}
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, keysConfigmapName, util.GetSHAfromData(urlData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = urlData
+	config.OldData = oldData
+	collectors = getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap keys")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	config.SHAValue = util.GetSHAfromKeys(urlData, []string{"url"})
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated with the SHA of the watched keys")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapKeyRefAndAutoReloadReferencedKeys(t *testing.T) {
+	options.AutoReloadReferencedKeys = true
+	defer func() {
+		options.AutoReloadReferencedKeys = false
+	}()
+
+	keyRefConfigmapName := "testkeyrefconfigmap-handler-" + testutil.RandSeq(5)
+	deploymentObj := testutil.GetDeploymentWithEnvVars(namespace, keyRefConfigmapName)
+	deploymentObj.Annotations = map[string]string{options.ReloaderAutoAnnotation: "true"}
+	deployment, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Create(context.TODO(), deploymentObj, v1.CreateOptions{})
+	if err != nil {
+		t.Errorf("Failed to create deployment with configmap key reference.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	oldData := map[string]string{"test.url": "www.stakater.com", "other": "value"}
+
+	// A change of a key which is not referenced must not reload the deployment
+	otherData := map[string]string{"test.url": "www.stakater.com", "other": "changed"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, keyRefConfigmapName, util.GetSHAfromData(otherData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = otherData
+	config.OldData = oldData
+	collectors := getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap key reference")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded upon a change of a key it does not reference")
+	}
+
+	// A change of the referenced key reloads the deployment with the SHA of this key only
+	urlData := map[string]string{"test.url": "www.stakater.com/changed", "other": "value"}
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, keyRefConfigmapName, util.GetSHAfromData(urlData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = urlData
+	config.OldData = oldData
+	collectors = getCollectors()
+
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap key reference")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	config.SHAValue = util.GetSHAfromKeys(urlData, []string{"test.url"})
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated with the SHA of the referenced key")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestIsResourceIgnored(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			name:        "TestResourceWithoutAnnotationShouldNotBeIgnored",
+			annotations: nil,
+			want:        false,
+		},
+		{
+			name:        "TestResourceWithIgnoreAnnotationShouldBeIgnored",
+			annotations: map[string]string{options.IgnoreResourceAnnotation: "true"},
+			want:        true,
+		},
+		{
+			name:        "TestResourceWithFalseIgnoreAnnotationShouldNotBeIgnored",
+			annotations: map[string]string{options.IgnoreResourceAnnotation: "false"},
+			want:        false,
+		},
+		{
+			name:        "TestResourceWithInvalidIgnoreAnnotationShouldNotBeIgnored",
+			annotations: map[string]string{options.IgnoreResourceAnnotation: "yes please"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configmap := testutil.GetConfigmap(namespace, "testignoredconfigmap-handler", "www.stakater.com")
+			configmap.Annotations = tt.annotations
+			config := util.GetConfigmapConfig(configmap)
+			if got := isResourceIgnored(config); got != tt.want {
+				t.Errorf("isResourceIgnored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapPatterns(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer events.SetRecorder(nil)
+
+	patternConfigmapName := "testpatternconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		patternConfigmapName,
+		namespace,
+		map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "re:testpattern-(, testpatternconfigmap-handler-*"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with configmap annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	for _, url := range []string{"www.stakater.com", "www.stakater.com/changed"} {
+		shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, patternConfigmapName, url)
+		config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, patternConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+		collectors := getCollectors()
+
+		err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+		if err != nil {
+			t.Errorf("Rolling upgrade failed for Deployment with Configmap patterns")
+		}
+
+		logrus.Infof("Verifying deployment update")
+		updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+		if !updated {
+			t.Errorf("Deployment was not updated by the glob")
+		}
+	}
+
+	// The invalid pattern is reported only once, besides the reloads
+	invalidPatternEvents := 0
+	for len(recorder.Events) > 0 {
+		event := <-recorder.Events
+		if strings.Contains(event, events.ReasonInvalidPattern) {
+			invalidPatternEvents++
+		} else if !strings.Contains(event, events.ReasonReload) {
+			t.Errorf("Unexpected event '%s', want reason '%s' or '%s'", event, events.ReasonInvalidPattern, events.ReasonReload)
+		}
+	}
+	if invalidPatternEvents != 1 {
+		t.Errorf("Recorded %d events for the invalid pattern, want 1", invalidPatternEvents)
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapInOtherNamespace(t *testing.T) {
+	sourceNamespace := "test-handler-source-" + testutil.RandSeq(5)
+	sharedConfigmapName := "testsharedconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		sharedConfigmapName,
+		namespace,
+		map[string]string{options.ConfigmapUpdateOnChangeAnnotation: sourceNamespace + "/" + sharedConfigmapName},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with configmap annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, sourceNamespace, sharedConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, sharedConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.Namespace = sourceNamespace
+	envName := constants.EnvVarPrefix + util.ConvertToEnvVarName(sourceNamespace+"/"+sharedConfigmapName) + "_" + constants.ConfigmapEnvVarPostfix
+
+	// The workload cache resolves the deployment referencing the configmap in another namespace, so that it is
+	// listed even if the namespace of the configmap is not allowed
+	workloadCache := workload.NewCache(clients, []string{v1.NamespaceAll})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+	workload.SetSharedCache(workloadCache)
+	defer workload.SetSharedCache(nil)
+	if items := getItems(clients, config, deploymentFuncs); len(items) != 1 {
+		t.Fatalf("getItems() returned %d items, want the deployment in another namespace", len(items))
+	}
+
+	// The deployment must not be reloaded as long as the namespace of the configmap is not allowed
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap in another namespace")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded by a configmap in a namespace which is not allowed")
+	}
+
+	options.AllowedCrossNamespaceSources = []string{sourceNamespace}
+	defer func() { options.AllowedCrossNamespaceSources = []string{} }()
+
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap in another namespace")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if testutil.GetResourceSHA(deploymentFuncs.ContainersFunc(updated), envName) != shaData {
+		t.Errorf("Deployment was not updated with the SHA of the configmap in another namespace")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForDeploymentListedInReloadTargets(t *testing.T) {
+	targetDeploymentName := "testtargetdeployment-handler-" + testutil.RandSeq(5)
+	targetsConfigmapName := "testtargetsconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		targetDeploymentName,
+		namespace,
+		map[string]string{},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment without annotations.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, targetsConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, targetsConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.ResourceAnnotations = map[string]string{
+		options.ReloadTargetsAnnotation: "statefulset/" + targetDeploymentName + ", Deployment/" + targetDeploymentName,
+	}
+	envName := constants.EnvVarPrefix + util.ConvertToEnvVarName(targetsConfigmapName) + "_" + constants.ConfigmapEnvVarPostfix
+
+	// Only the workloads of the listed kind are reloaded
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, GetStatefulSetRollingUpgradeFuncs(), collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for StatefulSet listed in reload targets")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("StatefulSet was reloaded although it does not exist")
+	}
+
+	// The workload cache does not index the reload targets, they are resolved separately
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	if items := appendReloadTargets(clients, config, deploymentFuncs, []interface{}{}, nil); len(items) != 1 {
+		t.Errorf("appendReloadTargets() returned %d items, want 1", len(items))
+	}
+	// With a workload cache, the targets are resolved from it instead of the API server
+	workloadCache := workload.NewCache(clients, []string{namespace})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+	if items := appendReloadTargets(kube.Clients{}, config, deploymentFuncs, []interface{}{}, workloadCache); len(items) != 1 {
+		t.Errorf("appendReloadTargets() returned %d items from the cache, want 1", len(items))
+	}
+
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment listed in reload targets")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if testutil.GetResourceSHA(deploymentFuncs.ContainersFunc(updated), envName) != shaData {
+		t.Errorf("Deployment listed in reload targets was not updated")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+	if promtestutil.ToFloat64(collectors.ReloadedByRule.With(prometheus.Labels{"rule": RuleReloadTargets})) != 1 {
+		t.Errorf("Counter of rule '%s' was not increased", RuleReloadTargets)
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConfigmapSelector(t *testing.T) {
+	selectorDeploymentName := "testselectordeployment-handler-" + testutil.RandSeq(5)
+	selectorConfigmapName := "testselectorconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		selectorDeploymentName,
+		namespace,
+		map[string]string{options.ConfigmapReloadSelectorAnnotation: "app=payments,tier=config"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with configmap selector annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, selectorConfigmapName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, selectorConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.SelectorAnnotation = options.ConfigmapReloadSelectorAnnotation
+	envName := constants.EnvVarPrefix + util.ConvertToEnvVarName(selectorConfigmapName) + "_" + constants.ConfigmapEnvVarPostfix
+
+	// A configmap whose labels do not match the selector must not reload the deployment
+	config.ResourceLabels = map[string]string{"app": "payments"}
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap selector")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded by a configmap not matching its selector")
+	}
+
+	config.ResourceLabels = map[string]string{"app": "payments", "tier": "config"}
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with Configmap selector")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if testutil.GetResourceSHA(deploymentFuncs.ContainersFunc(updated), envName) != shaData {
+		t.Errorf("Deployment was not updated by the configmap matching its selector")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForAutoReloadedDeploymentWithExcludedConfigmap(t *testing.T) {
+	excludeName := "testexclude-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		excludeName,
+		namespace,
+		map[string]string{
+			options.ReloaderAutoAnnotation:             "true",
+			options.ConfigmapExcludeReloaderAnnotation: "other-config, " + excludeName,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with exclude annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	// The excluded configmap must not reload the deployment
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, excludeName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, excludeName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.ExcludeAnnotation = options.ConfigmapExcludeReloaderAnnotation
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with excluded Configmap")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded by an excluded configmap")
+	}
+
+	// The secret of the same name is not excluded
+	shaData = testutil.ConvertResourceToSHA(testutil.SecretResourceType, namespace, excludeName, "dGVzdFVwZGF0ZWRTZWNyZXRFbmNvZGluZ0ZvclJlbG9hZGVy")
+	config = getConfigWithAnnotations(constants.SecretEnvVarPostfix, excludeName, shaData, options.SecretUpdateOnChangeAnnotation)
+	config.ExcludeAnnotation = options.SecretExcludeReloaderAnnotation
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with excluded Configmap")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.SecretEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated by the secret which is not excluded")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithSecretAutoAnnotation(t *testing.T) {
+	typedAutoName := "testsecretauto-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		typedAutoName,
+		namespace,
+		map[string]string{options.SecretReloaderAutoAnnotation: "true"},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with secret auto annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	// A change of a configmap used by the deployment must not reload it
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, typedAutoName, "www.stakater.com")
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, typedAutoName, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+	config.AutoAnnotation = options.ConfigmapReloaderAutoAnnotation
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with secret auto annotation")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment with secret auto annotation was reloaded by a configmap")
+	}
+
+	shaData = testutil.ConvertResourceToSHA(testutil.SecretResourceType, namespace, typedAutoName, "dGVzdFVwZGF0ZWRTZWNyZXRFbmNvZGluZ0ZvclJlbG9hZGVy")
+	config = getConfigWithAnnotations(constants.SecretEnvVarPostfix, typedAutoName, shaData, options.SecretUpdateOnChangeAnnotation)
+	config.AutoAnnotation = options.SecretReloaderAutoAnnotation
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with secret auto annotation")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployment.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	envName := constants.EnvVarPrefix + util.ConvertToEnvVarName(typedAutoName) + "_" + constants.SecretEnvVarPostfix
+	if testutil.GetResourceSHA(deploymentFuncs.ContainersFunc(updated), envName) != shaData {
+		t.Errorf("Deployment with secret auto annotation was not updated by a secret")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	deployment := testutil.GetDeploymentWithEnvVarSources(namespace, "testrules")
+	deployment.Annotations = map[string]string{
+		options.ReloaderAutoAnnotation: "true",
+		options.AutoSearchAnnotation:   "true",
+	}
+	// Annotations of the pod template combine with the ones of the workload
+	deployment.Spec.Template.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: "testrules",
+	}
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, "testrules", "sha", options.ConfigmapUpdateOnChangeAnnotation)
+	config.ResourceAnnotations = map[string]string{options.SearchMatchAnnotation: "true"}
+	matches := evaluateRules(GetDeploymentRollingUpgradeFuncs(), deployment, config)
+
+	want := []string{RuleAuto, RuleReference, RuleSearch}
+	if len(matches) != len(want) {
+		t.Fatalf("evaluateRules() returned %d matches, want %d", len(matches), len(want))
+	}
+	for i, match := range matches {
+		if match.rule != want[i] {
+			t.Errorf("evaluateRules() returned rule '%s' at %d, want '%s'", match.rule, i, want[i])
+		}
+		if match.reason == "" {
+			t.Errorf("evaluateRules() returned no reason for rule '%s'", match.rule)
+		}
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithCondition(t *testing.T) {
+	conditionConfigmapName := "testconditionconfigmap-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		conditionConfigmapName,
+		namespace,
+		map[string]string{
+			options.ConfigmapUpdateOnChangeAnnotation: conditionConfigmapName,
+			options.ReloadConditionAnnotation:         `old.data["version"] != new.data["version"]`,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with condition annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	oldData := map[string]string{"version": "1", "log_level": "info"}
+
+	// A change which does not meet the condition must not reload the deployment
+	logLevelData := map[string]string{"version": "1", "log_level": "debug"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conditionConfigmapName, util.GetSHAfromData(logLevelData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = logLevelData
+	config.OldData = oldData
+	collectors := getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with condition")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) > 0 {
+		t.Errorf("Deployment was reloaded although its condition is not met")
+	}
+
+	versionData := map[string]string{"version": "2", "log_level": "info"}
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conditionConfigmapName, util.GetSHAfromData(versionData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = versionData
+	config.OldData = oldData
+	collectors = getCollectors()
+	err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with condition")
+	}
+
+	logrus.Infof("Verifying deployment update")
+	updated := testutil.VerifyResourceUpdate(clients, config, constants.ConfigmapEnvVarPostfix, deploymentFuncs)
+	if !updated {
+		t.Errorf("Deployment was not updated although its condition is met")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+}
+
+func TestIsConditionMetWithInvalidCondition(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer events.SetRecorder(nil)
+
+	deployment := testutil.GetDeploymentWithEnvVarSources(namespace, "testinvalidcondition")
+	deployment.Annotations = map[string]string{options.ReloadConditionAnnotation: `old.data["version"] !=`}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, "testinvalidcondition", "sha", options.ConfigmapUpdateOnChangeAnnotation)
+
+	for i := 0; i < 2; i++ {
+		if isConditionMet(GetDeploymentRollingUpgradeFuncs(), deployment, config) {
+			t.Errorf("Invalid condition should never be met")
+		}
+	}
+
+	// The invalid condition is reported only once
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Recorded %d events for the invalid condition, want 1", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, events.ReasonInvalidCondition) {
+		t.Errorf("Unexpected event '%s', want reason '%s'", event, events.ReasonInvalidCondition)
+	}
+}
+
+func TestEvaluateSelectorRuleWithInvalidSelector(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer events.SetRecorder(nil)
+
+	deployment := testutil.GetDeploymentWithEnvVarSources(namespace, "testinvalidselector")
+	deployment.Annotations = map[string]string{options.ConfigmapReloadSelectorAnnotation: "reloader in (enabled"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, "testinvalidselector", "sha", options.ConfigmapUpdateOnChangeAnnotation)
+	config.SelectorAnnotation = options.ConfigmapReloadSelectorAnnotation
+	config.ResourceLabels = map[string]string{"reloader": "enabled"}
+
+	for i := 0; i < 2; i++ {
+		if matches := evaluateSelectorRule(GetDeploymentRollingUpgradeFuncs(), deployment, config); len(matches) != 0 {
+			t.Errorf("Invalid selector should never match")
+		}
+	}
+
+	// The invalid selector is reported only once
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Recorded %d events for the invalid selector, want 1", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, events.ReasonInvalidSelector) || !strings.Contains(event, "Invalid selector") {
+		t.Errorf("Unexpected event '%s', want reason '%s'", event, events.ReasonInvalidSelector)
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConditionOnlyEvaluatedForMatches(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer events.SetRecorder(nil)
+
+	conditionConfigmapName := "testconditionmatches-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		conditionConfigmapName,
+		namespace,
+		map[string]string{
+			options.ReloaderAutoAnnotation:    "true",
+			options.ReloadOnDeleteAnnotation:  constants.ReloadOnDelete,
+			options.ReloadConditionAnnotation: `old.data["version"] != new.data["version"]`,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with condition annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	// A configmap the deployment does not use must not evaluate its condition, which would fail without the key
+	unrelatedData := map[string]string{"url": "www.stakater.com"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, "testunrelated-"+testutil.RandSeq(5), util.GetSHAfromData(unrelatedData), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = unrelatedData
+	config.OldData = map[string]string{}
+	collectors := getCollectors()
+	if err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors); err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with condition")
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("Recorded %d events for an unrelated configmap, want 0: %s", len(recorder.Events), <-recorder.Events)
+	}
+
+	// Deletions reload regardless of the condition, which cannot be evaluated without data
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conditionConfigmapName, util.GetSHAfromDeletedResource(), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Deleted = true
+	collectors = getCollectors()
+	if err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors); err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with deleted Configmap")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Deployment with condition was not reloaded upon deletion of the configmap")
+	}
+}
+
+func TestRollingUpgradeForDeploymentWithConditionReconcilesMissedChange(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder)
+	defer events.SetRecorder(nil)
+
+	conditionConfigmapName := "testconditionreconcile-handler-" + testutil.RandSeq(5)
+	deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+		clients.KubernetesClient,
+		conditionConfigmapName,
+		namespace,
+		map[string]string{
+			options.ReloaderAutoAnnotation:    "true",
+			options.ReloadConditionAnnotation: `old.data["version"] != new.data["version"]`,
+		},
+	)
+	if err != nil {
+		t.Errorf("Failed to create deployment with condition annotation.")
+	}
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+	}()
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	// Record the SHA of the configmap upon an update meeting the condition
+	data := map[string]string{"version": "1"}
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conditionConfigmapName, util.GetSHAfromData(data), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = data
+	config.OldData = map[string]string{"version": "0"}
+	if err = PerformRollingUpgrade(clients, config, deploymentFuncs, getCollectors()); err != nil {
+		t.Errorf("Rolling upgrade failed for Deployment with condition")
+	}
+
+	// The change missed while Reloader was down is reconciled without the old state to evaluate the condition
+	data = map[string]string{"version": "2"}
+	config = getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, conditionConfigmapName, util.GetSHAfromData(data), options.ConfigmapUpdateOnChangeAnnotation)
+	config.Data = data
+	config.Reconcile = true
+	collectors := getCollectors()
+	if err = PerformRollingUpgrade(clients, config, deploymentFuncs, collectors); err != nil {
+		t.Errorf("Reconciliation failed for Deployment with condition")
+	}
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Deployment with condition was not reloaded upon reconciliation")
+	}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, events.ReasonInvalidCondition) {
+			t.Errorf("Condition was reported as invalid upon reconciliation: %s", event)
+		}
+	}
+}
+
+func TestResourceDeletedHandlerChecksExistence(t *testing.T) {
+	configmap := testutil.GetConfigmap("test", "testcm", "test")
+	configmap.UID = "1"
+	client := testclient.NewSimpleClientset(configmap)
+
+	// The watch reports a relabeled configmap which no longer matches the label selector as deleted
+	deleted, err := ResourceDeletedHandler{Resource: configmap, Client: client}.resourceDeleted()
+	if err != nil || deleted {
+		t.Errorf("resourceDeleted() = %t, %v for an existing configmap, want false", deleted, err)
+	}
+
+	recreated := configmap.DeepCopy()
+	recreated.UID = "2"
+	deleted, err = ResourceDeletedHandler{Resource: recreated, Client: client}.resourceDeleted()
+	if err != nil || !deleted {
+		t.Errorf("resourceDeleted() = %t, %v for a recreated configmap, want true", deleted, err)
+	}
+
+	missing := testutil.GetConfigmap("test", "missing", "test")
+	deleted, err = ResourceDeletedHandler{Resource: missing, Client: client}.resourceDeleted()
+	if err != nil || !deleted {
+		t.Errorf("resourceDeleted() = %t, %v for a deleted configmap, want true", deleted, err)
+	}
+
+	// Without a label selector the existence is never checked
+	deleted, err = ResourceDeletedHandler{Resource: configmap}.resourceDeleted()
+	if err != nil || !deleted {
+		t.Errorf("resourceDeleted() = %t, %v without a client, want true", deleted, err)
+	}
+
+	// Failed checks are returned so that the deletion is retried
+	client.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("unavailable")
+	})
+	if _, err := (ResourceDeletedHandler{Resource: missing, Client: client}).resourceDeleted(); err == nil {
+		t.Errorf("resourceDeleted() returned no error for a failed check")
+	}
+}
+
+func TestRollingUpgradeForDeploymentRemovesStaleEntriesOfPatterns(t *testing.T) {
+	defer func() { sourceCaches = map[string][]sourceCache{} }()
+	for _, strategy := range []string{constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy} {
+		t.Run(strategy, func(t *testing.T) {
+			prefix := "testgeneratedconfigmap-handler-" + testutil.RandSeq(5)
+			deployment, err := testutil.CreateDeploymentWithEnvVarSourceAndAnnotations(
+				clients.KubernetesClient,
+				prefix,
+				namespace,
+				map[string]string{
+					options.ConfigmapUpdateOnChangeAnnotation: prefix + "-*",
+					options.ReloadStrategyAnnotation:          strategy,
+				},
+			)
+			if err != nil {
+				t.Fatalf("Failed to create deployment: %v", err)
+			}
+			defer func() {
+				_ = clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), deployment.Name, v1.DeleteOptions{})
+			}()
+
+			store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+			sourceCaches = map[string][]sourceCache{constants.ConfigmapEnvVarPostfix: {{namespace: namespace, store: store}}}
+			deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+			getRecordedSHAs := func(names ...string) []string {
+				item, err := deploymentFuncs.ItemFunc(clients, deployment.Name, namespace)
+				if err != nil {
+					t.Fatalf("Failed to get deployment: %v", err)
+				}
+				shas := []string{}
+				for _, name := range names {
+					config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, name, "", options.ConfigmapUpdateOnChangeAnnotation)
+					shas = append(shas, getRecordedSHA(deploymentFuncs, item, config))
+				}
+				return shas
+			}
+
+			// Each generation of the configmap replaces the previous one, which is deleted
+			generations := []string{prefix + "-1", prefix + "-2", prefix + "-3"}
+			for i, name := range generations {
+				if i > 0 {
+					_ = store.Delete(testutil.GetConfigmap(namespace, generations[i-1], ""))
+				}
+				_ = store.Add(testutil.GetConfigmap(namespace, name, name))
+				shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, namespace, name, name)
+				config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, name, shaData, options.ConfigmapUpdateOnChangeAnnotation)
+				if err := PerformRollingUpgrade(clients, config, deploymentFuncs, getCollectors()); err != nil {
+					t.Fatalf("Rolling upgrade failed for Deployment with generated Configmap: %v", err)
+				}
+			}
+
+			shas := getRecordedSHAs(generations...)
+			if shas[0] != "" || shas[1] != "" {
+				t.Errorf("SHAs of deleted generations are still recorded: %v", shas[:2])
+			}
+			if shas[2] == "" {
+				t.Errorf("SHA of the latest generation is not recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package leadership
+
+import (
+	"context"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// GetNewLock returns the Lease lock used to elect the leader among the Reloader instances
+func GetNewLock(client coordinationv1.CoordinationV1Interface, leaseName string, identity string, namespace string) *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: client,
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+}
+
+// GetIdentity returns the identity of this Reloader instance in the leader election
+func GetIdentity() string {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		logrus.Fatalf("Unable to get hostname for leader election identity: %v", err)
+	}
+	return hostname
+}
+
+// RunLeaderElection blocks until this instance is elected as leader and then invokes onStartedLeading.
+// Once the leadership is lost, onStoppedLeading is invoked and RunLeaderElection returns
+func RunLeaderElection(ctx context.Context, lock resourcelock.Interface, collectors metrics.Collectors, onStartedLeading func(context.Context), onStoppedLeading func()) {
+	identity := lock.Identity()
+	collectors.Leader.Set(0)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   options.LeaderElectionLeaseDuration,
+		RenewDeadline:   options.LeaderElectionRenewDeadline,
+		RetryPeriod:     options.LeaderElectionRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logrus.Infof("Instance '%s' acquired the leadership, starting to reload workloads", identity)
+				collectors.Leader.Set(1)
+				onStartedLeading(ctx)
+			},
+			OnStoppedLeading: func() {
+				logrus.Warnf("Instance '%s' lost the leadership", identity)
+				collectors.Leader.Set(0)
+				onStoppedLeading()
+			},
+			OnNewLeader: func(currentLeader string) {
+				if currentLeader != identity {
+					logrus.Infof("Instance '%s' is the leader, '%s' is standing by", currentLeader, identity)
+				}
+			},
+		},
+	})
+}
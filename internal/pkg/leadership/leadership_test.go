@@ -0,0 +1,61 @@
+package leadership
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunLeaderElection(t *testing.T) {
+	options.LeaderElectionLeaseDuration = 2 * time.Second
+	options.LeaderElectionRenewDeadline = 1 * time.Second
+	options.LeaderElectionRetryPeriod = 100 * time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+	collectors := metrics.NewCollectors()
+	lock := GetNewLock(clientset.CoordinationV1(), "reloader", "reloader-0", "default")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	go RunLeaderElection(ctx, lock, collectors, func(context.Context) {
+		close(started)
+	}, func() {
+		close(stopped)
+	})
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Leadership was not acquired")
+	}
+
+	if testutil.ToFloat64(collectors.Leader) != 1 {
+		t.Errorf("Leader metric was not set upon acquiring the leadership")
+	}
+
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), "reloader", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Lease was not created: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "reloader-0" {
+		t.Errorf("Lease is not held by the leader")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Leadership was not released")
+	}
+
+	if testutil.ToFloat64(collectors.Leader) != 0 {
+		t.Errorf("Leader metric was not reset upon losing the leadership")
+	}
+}
@@ -8,7 +8,10 @@ import (
 )
 
 type Collectors struct {
-	Reloaded *prometheus.CounterVec
+	Reloaded       *prometheus.CounterVec
+	ReloadedByRule *prometheus.CounterVec
+	DryRunReloaded prometheus.Counter
+	Leader         prometheus.Gauge
 }
 
 func NewCollectors() Collectors {
@@ -25,14 +28,45 @@ func NewCollectors() Collectors {
 	reloaded.With(prometheus.Labels{"success": "true"}).Add(0)
 	reloaded.With(prometheus.Labels{"success": "false"}).Add(0)
 
+	reloadedByRule := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "reload_rule_total",
+			Help:      "Counter of reloads executed by Reloader by the rule which matched the workload.",
+		},
+		[]string{"rule"},
+	)
+
+	dryRunReloaded := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "dry_run_reload_total",
+			Help:      "Counter of reloads Reloader would have executed outside of dry-run mode.",
+		},
+	)
+
+	leader := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "reloader",
+			Name:      "is_leader",
+			Help:      "Whether this instance of Reloader is the leader and reloads workloads.",
+		},
+	)
+
 	return Collectors{
-		Reloaded: reloaded,
+		Reloaded:       reloaded,
+		ReloadedByRule: reloadedByRule,
+		DryRunReloaded: dryRunReloaded,
+		Leader:         leader,
 	}
 }
 
 func SetupPrometheusEndpoint() Collectors {
 	collectors := NewCollectors()
 	prometheus.MustRegister(collectors.Reloaded)
+	prometheus.MustRegister(collectors.ReloadedByRule)
+	prometheus.MustRegister(collectors.DryRunReloaded)
+	prometheus.MustRegister(collectors.Leader)
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -1,5 +1,7 @@
 package options
 
+import "time"
+
 var (
 	// ConfigmapUpdateOnChangeAnnotation is an annotation to detect changes in
 	// configmaps specified by name
@@ -7,14 +9,120 @@ var (
 	// SecretUpdateOnChangeAnnotation is an annotation to detect changes in
 	// secrets specified by name
 	SecretUpdateOnChangeAnnotation = "secret.reloader.stakater.com/reload"
+	// ConfigmapReloadSelectorAnnotation is an annotation to detect changes in
+	// the configmaps matching a label selector
+	ConfigmapReloadSelectorAnnotation = "configmap.reloader.stakater.com/reload-selector"
+	// SecretReloadSelectorAnnotation is an annotation to detect changes in
+	// the secrets matching a label selector
+	SecretReloadSelectorAnnotation = "secret.reloader.stakater.com/reload-selector"
+	// ConfigmapExcludeReloaderAnnotation is an annotation to exclude configmaps
+	// specified by name from the automatic reload
+	ConfigmapExcludeReloaderAnnotation = "configmaps.exclude.reloader.stakater.com/reload"
+	// SecretExcludeReloaderAnnotation is an annotation to exclude secrets
+	// specified by name from the automatic reload
+	SecretExcludeReloaderAnnotation = "secrets.exclude.reloader.stakater.com/reload"
 	// ReloaderAutoAnnotation is an annotation to detect changes in secrets
 	ReloaderAutoAnnotation = "reloader.stakater.com/auto"
+	// ConfigmapReloaderAutoAnnotation is an annotation to detect changes in
+	// the configmaps used by a workload only
+	ConfigmapReloaderAutoAnnotation = "configmap.reloader.stakater.com/auto"
+	// SecretReloaderAutoAnnotation is an annotation to detect changes in
+	// the secrets used by a workload only
+	SecretReloaderAutoAnnotation = "secret.reloader.stakater.com/auto"
 	// AutoSearchAnnotation is an annotation to detect changes in
 	// configmaps or triggers with the SearchMatchAnnotation
 	AutoSearchAnnotation = "reloader.stakater.com/search"
 	// SearchMatchAnnotation is an annotation to tag secrets to be found with
 	// AutoSearchAnnotation
 	SearchMatchAnnotation = "reloader.stakater.com/match"
+	// IgnoreResourceAnnotation is an annotation on configmaps and secrets to
+	// prevent them from triggering reloads
+	IgnoreResourceAnnotation = "reloader.stakater.com/ignore"
+	// IgnoreKeysAnnotation is an annotation on configmaps and secrets listing
+	// the names and globs of keys whose changes never trigger reloads
+	IgnoreKeysAnnotation = "reloader.stakater.com/ignore-keys"
+	// SemanticHashAnnotation is an annotation on configmaps to hash the
+	// canonical form of their JSON, YAML, TOML and properties values
+	SemanticHashAnnotation = "reloader.stakater.com/semantic-hash"
+	// ReloadTargetsAnnotation is an annotation on configmaps and secrets
+	// listing the workloads to reload upon their changes
+	ReloadTargetsAnnotation = "reloader.stakater.com/reload-targets"
+	// ReloadConditionAnnotation is an annotation holding a CEL expression
+	// which has to be true for a workload to be reloaded
+	ReloadConditionAnnotation = "reloader.stakater.com/condition"
+	// ReloadOnManagersAnnotation is an annotation listing the field managers
+	// whose changes of configmaps and secrets reload a workload
+	ReloadOnManagersAnnotation = "reloader.stakater.com/reload-on-managers"
+	// IgnoreManagersAnnotation is an annotation listing the field managers
+	// whose changes of configmaps and secrets never reload a workload
+	IgnoreManagersAnnotation = "reloader.stakater.com/ignore-managers"
+	// ReloadOnDeleteAnnotation is an annotation to define whether a workload
+	// is reloaded when a configmap or secret it uses is deleted
+	ReloadOnDeleteAnnotation = "reloader.stakater.com/on-delete"
+	// ReloadStrategyAnnotation is an annotation to override the ReloadStrategy
+	// of a single workload
+	ReloadStrategyAnnotation = "reloader.stakater.com/reload-strategy"
+	// ReloadStrategy defines how a rolling upgrade is triggered, either by
+	// updating an env var of a container or an annotation of the pod template
+	ReloadStrategy = "env-vars"
+	// AutoReloadReferencedKeys restricts the automatic reload of a workload to
+	// changes of the keys its pod template references, if it references only
+	// specific keys of a configmap or secret
+	AutoReloadReferencedKeys = false
+	// AllowedCrossNamespaceSources are the namespaces whose configmaps and
+	// secrets may be referenced by workloads in other namespaces
+	AllowedCrossNamespaceSources = []string{}
+	// DryRun reports the workloads which would be reloaded instead of reloading them
+	DryRun = false
+	// DryRunAnnotation is an annotation on a namespace to override DryRun for
+	// the workloads in it
+	DryRunAnnotation = "reloader.stakater.com/dry-run"
+	// NamespaceSelector is a label selector on namespaces, only the configmaps
+	// and secrets in matching namespaces are watched
+	NamespaceSelector = ""
+	// ResourceLabelSelector is a label selector on configmaps and secrets, only
+	// matching ones are watched
+	ResourceLabelSelector = ""
+	// ConfigmapLabelSelector is a label selector on configmaps, it takes
+	// precedence over ResourceLabelSelector
+	ConfigmapLabelSelector = ""
+	// SecretLabelSelector is a label selector on secrets, it takes precedence
+	// over ResourceLabelSelector
+	SecretLabelSelector = ""
+	// ReloadOnManagers are the field managers whose changes of configmaps and
+	// secrets trigger reloads, all managers if empty
+	ReloadOnManagers = []string{}
+	// IgnoredManagers are the field managers whose changes of configmaps and
+	// secrets never trigger reloads
+	IgnoredManagers = []string{}
+	// IgnoredSecretTypes are the types of secrets which are never watched
+	IgnoredSecretTypes = []string{"helm.sh/release.v1", "kubernetes.io/service-account-token"}
+	// ReconcileOnStartup reloads the workloads whose recorded SHA of a configmap
+	// or secret is out of date when Reloader starts, to catch up on missed changes
+	ReconcileOnStartup = false
+	// ResyncPeriod is the interval at which the recorded SHAs of the workloads
+	// are compared again with the configmaps and secrets, 0 disables it
+	ResyncPeriod time.Duration = 0
+	// CacheSyncTimeout is the duration Reloader waits for its caches to sync
+	// at startup, e.g. when it is not permitted to watch the workloads
+	CacheSyncTimeout = time.Minute
+	// EnableHA enables the leader election, so that only the leader among
+	// several Reloader instances reloads workloads
+	EnableHA = false
+	// LeaderElectionLeaseName is the name of the Lease used for the leader election
+	LeaderElectionLeaseName = "reloader"
+	// LeaderElectionNamespace is the namespace of the Lease used for the
+	// leader election, defaults to the namespace Reloader runs in
+	LeaderElectionNamespace = ""
+	// LeaderElectionLeaseDuration is the duration standby instances wait
+	// before taking over the leadership of an unresponsive leader
+	LeaderElectionLeaseDuration = 15 * time.Second
+	// LeaderElectionRenewDeadline is the duration the leader retries to
+	// renew its leadership before giving it up
+	LeaderElectionRenewDeadline = 10 * time.Second
+	// LeaderElectionRetryPeriod is the duration instances wait between
+	// attempts to acquire or renew the leadership
+	LeaderElectionRetryPeriod = 2 * time.Second
 	// LogFormat is the log format to use (json, or empty string for default)
 	LogFormat = ""
 	// Adds support for argo rollouts
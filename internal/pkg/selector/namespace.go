@@ -0,0 +1,123 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceSelector keeps the namespaces matching a label selector in an informer, so that namespaces
+// which are created or relabeled are picked up or dropped while Reloader is running
+type NamespaceSelector struct {
+	informer cache.SharedIndexInformer
+}
+
+var (
+	// sharedNamespaces is the informer the annotations of namespaces are read from, if any
+	sharedNamespaces *NamespaceSelector
+	// lazyNamespaces starts the informer the annotations of namespaces are read from upon first use, if set
+	lazyNamespaces func() *NamespaceSelector
+)
+
+// SetSharedNamespaces sets the informer the annotations of namespaces are read from
+func SetSharedNamespaces(s *NamespaceSelector) {
+	sharedNamespaces = s
+	lazyNamespaces = nil
+}
+
+// SetLazySharedNamespaces caches all namespaces once their annotations are read for the first time, so that
+// namespaces are neither read nor waited for at startup. If the cache does not sync within the timeout, e.g. as
+// Reloader is not permitted to watch namespaces, it is stopped and namespaces are not cached
+func SetLazySharedNamespaces(client kubernetes.Interface, timeout time.Duration) {
+	var once sync.Once
+	var namespaces *NamespaceSelector
+	sharedNamespaces = nil
+	lazyNamespaces = func() *NamespaceSelector {
+		once.Do(func() {
+			allNamespaces, err := NewNamespaceSelector(client, "")
+			if err != nil {
+				logrus.Errorf("Failed to cache namespaces: %v", err)
+				return
+			}
+			stopCh := make(chan struct{})
+			if !allNamespaces.Run(stopCh, timeout) {
+				close(stopCh)
+				logrus.Warnf("Timed out after %s waiting for namespace cache to sync, namespace annotations are ignored. Grant the 'list' and 'watch' verbs on 'namespaces' to read them", timeout)
+				return
+			}
+			namespaces = allNamespaces
+		})
+		return namespaces
+	}
+}
+
+// GetSharedNamespaces returns the informer the annotations of namespaces are read from, or nil if namespaces
+// are not cached, e.g. as only certain namespaces are watched without permission to read them
+func GetSharedNamespaces() *NamespaceSelector {
+	if lazyNamespaces != nil {
+		return lazyNamespaces()
+	}
+	return sharedNamespaces
+}
+
+// NewNamespaceSelector creates an informer of the namespaces matching the label selector
+func NewNamespaceSelector(client kubernetes.Interface, labelSelector string) (*NamespaceSelector, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	listWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.String()
+			return client.CoreV1().Namespaces().List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return client.CoreV1().Namespaces().Watch(context.TODO(), options)
+		},
+	}
+	return &NamespaceSelector{
+		informer: cache.NewSharedIndexInformer(listWatcher, &v1.Namespace{}, 0, cache.Indexers{}),
+	}, nil
+}
+
+// Run starts the informer and blocks until it is synced or the timeout expired. It returns whether it synced
+func (s *NamespaceSelector) Run(stopCh <-chan struct{}, timeout time.Duration) bool {
+	logrus.Infof("Starting informer to select namespaces")
+	go s.informer.Run(stopCh)
+	return util.WaitForCacheSync(stopCh, timeout, s.informer.HasSynced)
+}
+
+// Matches checks whether the namespace currently matches the label selector
+func (s *NamespaceSelector) Matches(namespace string) bool {
+	_, exists, err := s.informer.GetStore().GetByKey(namespace)
+	if err != nil {
+		logrus.Errorf("Failed to get namespace '%s' from cache: %v", namespace, err)
+		return false
+	}
+	return exists
+}
+
+// Annotations returns the annotations of the namespace from the cache, and whether the namespace is cached
+func (s *NamespaceSelector) Annotations(namespace string) (map[string]string, bool) {
+	object, exists, err := s.informer.GetStore().GetByKey(namespace)
+	if err != nil {
+		logrus.Errorf("Failed to get namespace '%s' from cache: %v", namespace, err)
+		return nil, false
+	}
+	if !exists {
+		return nil, false
+	}
+	return object.(*v1.Namespace).Annotations, true
+}
@@ -0,0 +1,99 @@
+package selector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func getNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestNewNamespaceSelectorWithInvalidSelector(t *testing.T) {
+	if _, err := NewNamespaceSelector(testclient.NewSimpleClientset(), "reloader in (enabled"); err == nil {
+		t.Errorf("NewNamespaceSelector() accepted an invalid label selector")
+	}
+}
+
+func TestNamespaceSelectorMatches(t *testing.T) {
+	client := testclient.NewSimpleClientset(
+		getNamespace("selected", map[string]string{"reloader": "enabled"}),
+		getNamespace("unselected", map[string]string{"reloader": "disabled"}),
+	)
+	namespaceSelector, err := NewNamespaceSelector(client, "reloader=enabled")
+	if err != nil {
+		t.Fatalf("NewNamespaceSelector() failed: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if !namespaceSelector.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	if !namespaceSelector.Matches("selected") {
+		t.Errorf("Namespace 'selected' is not selected")
+	}
+	if namespaceSelector.Matches("unselected") {
+		t.Errorf("Namespace 'unselected' is selected unexpectedly")
+	}
+
+	// Relabeling the namespace selects it without restarting the informer
+	_, err = client.CoreV1().Namespaces().Update(context.TODO(), getNamespace("unselected", map[string]string{"reloader": "enabled"}), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to relabel namespace: %v", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return namespaceSelector.Matches("unselected"), nil
+	})
+	if err != nil {
+		t.Errorf("Relabeled namespace 'unselected' was not selected")
+	}
+}
+
+func TestLazySharedNamespaces(t *testing.T) {
+	client := testclient.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{"reloader.stakater.com/dry-run": "true"}},
+	})
+	SetLazySharedNamespaces(client, time.Minute)
+	defer SetSharedNamespaces(nil)
+
+	if len(client.Actions()) != 0 {
+		t.Errorf("Namespaces were read before their annotations were needed")
+	}
+	namespaces := GetSharedNamespaces()
+	if namespaces == nil {
+		t.Fatalf("GetSharedNamespaces() = nil, want cached namespaces")
+	}
+	annotations, found := namespaces.Annotations("annotated")
+	if !found || annotations["reloader.stakater.com/dry-run"] != "true" {
+		t.Errorf("Annotations() = %v, %t, want the annotations of namespace 'annotated'", annotations, found)
+	}
+}
+
+func TestLazySharedNamespacesWithoutPermission(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	client.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(v1.Resource("namespaces"), "", fmt.Errorf("forbidden"))
+	})
+	SetLazySharedNamespaces(client, 100*time.Millisecond)
+	defer SetSharedNamespaces(nil)
+
+	if namespaces := GetSharedNamespaces(); namespaces != nil {
+		t.Errorf("GetSharedNamespaces() returned namespaces which could not be listed")
+	}
+}
@@ -2,6 +2,7 @@ package testutil
 
 import (
 	"context"
+	"encoding/json"
 	"math/rand"
 	"sort"
 	"strconv"
@@ -576,6 +577,15 @@ func GetResourceSHA(containers []v1.Container, envVar string) string {
 	return ""
 }
 
+// GetResourceSHAFromAnnotation returns the SHA value of given resource recorded in the pod annotations
+func GetResourceSHAFromAnnotation(podAnnotations map[string]string, resourceType string, resourceName string) string {
+	hashes := map[string]string{}
+	if err := json.Unmarshal([]byte(podAnnotations[constants.ReloadedHashesAnnotation]), &hashes); err != nil {
+		return ""
+	}
+	return hashes[strings.ToLower(resourceType)+"/"+resourceName]
+}
+
 //ConvertResourceToSHA generates SHA from secret or configmap data
 func ConvertResourceToSHA(resourceType string, namespace string, resourceName string, data string) string {
 	values := []string{}
@@ -820,10 +830,8 @@ func VerifyResourceUpdate(clients kube.Clients, config util.Config, envVarPostfi
 		if err == nil && reloaderEnabled {
 			matches = true
 		} else if annotationValue != "" {
-			values := strings.Split(annotationValue, ",")
-			for _, value := range values {
-				value = strings.Trim(value, " ")
-				if value == config.ResourceName {
+			for _, reference := range util.ParseResourceReferences(annotationValue) {
+				if referenceMatches, _ := reference.Matches(config.ResourceName); referenceMatches {
 					matches = true
 					break
 				}
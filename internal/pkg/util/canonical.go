@@ -0,0 +1,209 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// CanonicalizeValue returns the canonical form of a structured value of a configmap, so that reformatting it,
+// reordering its keys or editing its comments does not change its hash. The format is chosen by the extension
+// of the key, i.e. .json, .yaml, .yml, .toml or .properties. Values of other keys and values which fail to
+// parse are returned unchanged
+func CanonicalizeValue(key string, value string) string {
+	var canonical string
+	var err error
+	switch strings.ToLower(path.Ext(key)) {
+	case ".json":
+		canonical, err = canonicalizeJSON([]byte(value))
+	case ".yaml", ".yml":
+		canonical, err = canonicalizeYAML(value)
+	case ".toml":
+		canonical, err = canonicalizeTOML(value)
+	case ".properties":
+		canonical = canonicalizeProperties(value)
+	default:
+		return value
+	}
+	if err != nil {
+		return value
+	}
+	return canonical
+}
+
+// canonicalizeJSON re-encodes the JSON value, which sorts the keys of objects and drops insignificant whitespace.
+// Numbers are kept as they are written, so that large integers do not lose precision
+func canonicalizeJSON(value []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return "", err
+	}
+	// Trailing values would be dropped from the canonical form, so that changing them would not change the hash
+	var trailing interface{}
+	if err := decoder.Decode(&trailing); err != io.EOF {
+		return "", errors.New("unexpected content after the JSON value")
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// canonicalizeYAML returns the canonical forms of all documents of the YAML value, separated by '---'
+func canonicalizeYAML(value string) (string, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(value)))
+	documents := []string{}
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		// Only the first of several documents in a chunk would be converted, e.g. after '...' or '--- a: 1'
+		if hasDocumentMarker(document) {
+			return "", errors.New("unsupported document marker in the YAML value")
+		}
+		jsonValue, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return "", err
+		}
+		canonical, err := canonicalizeJSON(jsonValue)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, canonical)
+	}
+	return strings.Join(documents, "\n---\n"), nil
+}
+
+// hasDocumentMarker checks whether a line of the YAML document starts or ends a document
+func hasDocumentMarker(document []byte) bool {
+	for _, line := range strings.Split(string(document), "\n") {
+		for _, marker := range []string{"---", "..."} {
+			if line == marker || strings.HasPrefix(line, marker+" ") || strings.HasPrefix(line, marker+"\t") || strings.HasPrefix(line, marker+"\r") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func canonicalizeTOML(value string) (string, error) {
+	decoded := map[string]interface{}{}
+	if _, err := toml.Decode(value, &decoded); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// canonicalizeProperties returns the sorted properties of a .properties file without comments, blank lines,
+// line continuations and escapes. A property defined more than once keeps its last value
+func canonicalizeProperties(value string) string {
+	properties := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for endsWithContinuation(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+
+		key, rest := splitProperty(line)
+		properties[unescapeProperty(key)] = unescapeProperty(rest)
+	}
+
+	entries := make([]string, 0, len(properties))
+	for key, value := range properties {
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+// endsWithContinuation checks whether the line ends with an odd number of backslashes, which continues it on the next line
+func endsWithContinuation(line string) bool {
+	backslashes := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// splitProperty splits the line at the first unescaped '=', ':' or whitespace into the key and the value
+func splitProperty(line string) (string, string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	key := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+func unescapeProperty(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var buffer strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			buffer.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 't':
+			buffer.WriteByte('\t')
+		case 'n':
+			buffer.WriteByte('\n')
+		case 'r':
+			buffer.WriteByte('\r')
+		case 'f':
+			buffer.WriteByte('\f')
+		case 'u':
+			if i+4 < len(value) {
+				if r, err := strconv.ParseUint(value[i+1:i+5], 16, 32); err == nil {
+					buffer.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			buffer.WriteByte('u')
+		default:
+			buffer.WriteByte(value[i])
+		}
+	}
+	return buffer.String()
+}
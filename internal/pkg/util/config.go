@@ -11,9 +11,21 @@ type Config struct {
 	Namespace           string
 	ResourceName        string
 	ResourceAnnotations map[string]string
+	ResourceLabels      map[string]string
 	Annotation          string
+	SelectorAnnotation  string
+	AutoAnnotation      string
+	ExcludeAnnotation   string
 	SHAValue            string
 	Type                string
+	Data                map[string]string
+	OldData             map[string]string
+	OldLabels           map[string]string
+	OldAnnotations      map[string]string
+	Managers            []string
+	Deleted             bool
+	Reconcile           bool
+	CrossNamespace      bool
 }
 
 // GetConfigmapConfig provides utility config for configmap
@@ -22,8 +34,13 @@ func GetConfigmapConfig(configmap *v1.ConfigMap) Config {
 		Namespace:           configmap.Namespace,
 		ResourceName:        configmap.Name,
 		ResourceAnnotations: configmap.Annotations,
+		ResourceLabels:      configmap.Labels,
 		Annotation:          options.ConfigmapUpdateOnChangeAnnotation,
+		SelectorAnnotation:  options.ConfigmapReloadSelectorAnnotation,
+		AutoAnnotation:      options.ConfigmapReloaderAutoAnnotation,
+		ExcludeAnnotation:   options.ConfigmapExcludeReloaderAnnotation,
 		SHAValue:            GetSHAfromConfigmap(configmap),
+		Data:                GetConfigmapData(configmap),
 		Type:                constants.ConfigmapEnvVarPostfix,
 	}
 }
@@ -34,8 +51,13 @@ func GetSecretConfig(secret *v1.Secret) Config {
 		Namespace:           secret.Namespace,
 		ResourceName:        secret.Name,
 		ResourceAnnotations: secret.Annotations,
+		ResourceLabels:      secret.Labels,
 		Annotation:          options.SecretUpdateOnChangeAnnotation,
-		SHAValue:            GetSHAfromSecret(secret.Data),
+		SelectorAnnotation:  options.SecretReloadSelectorAnnotation,
+		AutoAnnotation:      options.SecretReloaderAutoAnnotation,
+		ExcludeAnnotation:   options.SecretExcludeReloaderAnnotation,
+		SHAValue:            GetSHAfromSecret(secret),
+		Data:                GetSecretData(secret),
 		Type:                constants.SecretEnvVarPostfix,
 	}
 }
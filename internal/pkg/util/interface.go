@@ -8,7 +8,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// InterfaceSlice converts an interface to an interface array
+// InterfaceSlice converts an interface to an interface array holding pointers to the slice elements,
+// so that the elements can be modified in place
 func InterfaceSlice(slice interface{}) []interface{} {
 	s := reflect.ValueOf(slice)
 	if s.Kind() != reflect.Slice {
@@ -18,7 +19,7 @@ func InterfaceSlice(slice interface{}) []interface{} {
 	ret := make([]interface{}, s.Len())
 
 	for i := 0; i < s.Len(); i++ {
-		ret[i] = s.Index(i).Interface()
+		ret[i] = s.Index(i).Addr().Interface()
 	}
 
 	return ret
@@ -29,7 +30,7 @@ type ObjectMeta struct {
 }
 
 func ToObjectMeta(kubernetesObject interface{}) ObjectMeta {
-	objectValue := reflect.ValueOf(kubernetesObject)
+	objectValue := reflect.Indirect(reflect.ValueOf(kubernetesObject))
 	fieldName := reflect.TypeOf((*metav1.ObjectMeta)(nil)).Elem().Name()
 	field := objectValue.FieldByName(fieldName).Interface().(metav1.ObjectMeta)
 
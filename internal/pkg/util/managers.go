@@ -0,0 +1,116 @@
+package util
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dataFields are the fields of configmaps and secrets holding their entries in managed fields
+var dataFields = []string{"f:data", "f:binaryData", "f:stringData"}
+
+// GetChangedKeys returns the sorted keys which were added, removed or changed between the old and new data
+func GetChangedKeys(oldData map[string]string, newData map[string]string) []string {
+	keys := []string{}
+	for key, value := range newData {
+		if oldValue, found := oldData[key]; !found || oldValue != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range oldData {
+		if _, found := newData[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetDataManagers returns the sorted field managers of the given keys of a configmap or secret, as recorded in its
+// managed fields. If no key has a manager, e.g. as the keys were removed, the manager of the latest change of the
+// configmap or secret is returned, regardless of the fields it still owns
+func GetDataManagers(managedFields []metav1.ManagedFieldsEntry, keys []string) []string {
+	managers := map[string]bool{}
+	latestManager := ""
+	var latestTime *metav1.Time
+	for _, entry := range managedFields {
+		if latestManager == "" || (entry.Time != nil && (latestTime == nil || latestTime.Before(entry.Time))) {
+			latestManager, latestTime = entry.Manager, entry.Time
+		}
+		managedKeys := getManagedDataKeys(entry)
+		for _, key := range keys {
+			if managedKeys[key] {
+				managers[entry.Manager] = true
+			}
+		}
+	}
+
+	if len(managers) == 0 && latestManager != "" {
+		return []string{latestManager}
+	}
+	result := make([]string, 0, len(managers))
+	for manager := range managers {
+		result = append(result, manager)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ParseManagers returns the field managers of a comma separated list, without blanks and empty values
+func ParseManagers(value string) []string {
+	managers := []string{}
+	for _, manager := range strings.Split(value, ",") {
+		if manager = strings.TrimSpace(manager); manager != "" {
+			managers = append(managers, manager)
+		}
+	}
+	return managers
+}
+
+// AnyManagerAllowed checks whether any of the managers matches the allowed managers, all if none are allowed
+// explicitly, and none of the ignored managers
+func AnyManagerAllowed(managers []string, allowed []string, ignored []string) bool {
+	for _, manager := range managers {
+		if (len(allowed) == 0 || MatchesAny(allowed, manager)) && !MatchesAny(ignored, manager) {
+			return true
+		}
+	}
+	return false
+}
+
+// getManagedDataKeys returns the keys of the entries of a configmap or secret managed by the entry of the managed fields
+func getManagedDataKeys(entry metav1.ManagedFieldsEntry) map[string]bool {
+	keys := map[string]bool{}
+	if entry.FieldsV1 == nil {
+		return keys
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+		return keys
+	}
+	for _, dataField := range dataFields {
+		entries := map[string]json.RawMessage{}
+		if raw, found := fields[dataField]; !found || json.Unmarshal(raw, &entries) != nil {
+			continue
+		}
+		for field := range entries {
+			if strings.HasPrefix(field, "f:") {
+				keys[strings.TrimPrefix(field, "f:")] = true
+			}
+		}
+	}
+	return keys
+}
+
+// MatchesAny checks whether the name matches any of the exact names or globs. Invalid globs match no name
+func MatchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matches, err := path.Match(strings.TrimSpace(pattern), name); err == nil && matches {
+			return true
+		}
+	}
+	return false
+}
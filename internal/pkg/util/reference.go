@@ -0,0 +1,214 @@
+package util
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegexPrefix marks a name of a reload annotation as a regular expression
+const RegexPrefix = "re:"
+
+// globCharacters are the characters which make a name of a reload annotation a glob
+const globCharacters = "*?["
+
+// regexps caches the compiled regular expressions of reload annotations by their pattern
+var regexps sync.Map
+
+// ResourceReference is a configmap or secret named in a reload annotation. If Namespace is empty, the configmap
+// or secret is in the namespace of the workload. If Keys is not empty, only changes of these keys are relevant
+type ResourceReference struct {
+	Namespace string
+	Name      string
+	Keys      []string
+}
+
+// ParseResourceReferences parses the comma separated names of a reload annotation. Each name may be prefixed
+// by a namespace and followed by the keys to watch in brackets after a '#', e.g. "app-config-*#[log_level,db_url]" or
+// "re:app-\d{1,3}#[url]". The '#' may be omitted after a plain name, e.g. "app-config[log_level],central/other-config",
+// otherwise brackets are part of the glob or regular expression, e.g. "app-[ab]"
+func ParseResourceReferences(value string) []ResourceReference {
+	references := []ResourceReference{}
+	for {
+		end := referenceEnd(value)
+		if reference, ok := parseResourceReference(value[:end]); ok {
+			references = append(references, reference)
+		}
+		if end == len(value) {
+			return references
+		}
+		value = value[end+1:]
+	}
+}
+
+// referenceEnd returns the index of the comma ending the first reference of the value, or the length of the value.
+// Commas in key lists and, for regular expressions, in brackets, braces, parentheses or escaped do not end it. If
+// these are never closed, e.g. in an invalid regular expression, the first comma ends it
+func referenceEnd(value string) int {
+	regex := isRegexReference(value)
+	depth := 0
+	firstComma := -1
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if regex {
+				i++
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '{', '(':
+			if regex {
+				depth++
+			}
+		case '}', ')':
+			if regex {
+				depth--
+			}
+		case '#':
+			// The keys of a regular expression follow its end
+			if regex && depth <= 0 && strings.HasPrefix(value[i+1:], "[") {
+				regex = false
+			}
+		case ',':
+			if depth <= 0 {
+				return i
+			}
+			if firstComma < 0 {
+				firstComma = i
+			}
+		}
+	}
+	if depth > 0 && firstComma >= 0 {
+		return firstComma
+	}
+	return len(value)
+}
+
+// isRegexReference checks whether the reference at the start of the value is a regular expression, which may be
+// preceded by a namespace
+func isRegexReference(value string) bool {
+	value = strings.TrimSpace(value)
+	if slash := strings.Index(value, "/"); slash >= 0 && namespacePattern.MatchString(strings.TrimSpace(value[:slash])) {
+		value = strings.TrimSpace(value[slash+1:])
+	}
+	return strings.HasPrefix(value, RegexPrefix)
+}
+
+// namespacePattern matches the names of namespaces
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+func parseResourceReference(value string) (ResourceReference, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ResourceReference{}, false
+	}
+
+	reference := ResourceReference{Name: value}
+	if open := strings.LastIndex(value, "#["); open >= 0 && strings.HasSuffix(value, "]") {
+		reference.Name = strings.TrimSpace(value[:open])
+		reference.Keys = parseKeys(value[open+len("#[") : len(value)-1])
+	} else if open := strings.Index(value, "["); open >= 0 && strings.HasSuffix(value, "]") && isResourceName(value[:open]) {
+		// Brackets after a plain name list its keys, otherwise they are part of a glob or a regular expression
+		reference.Name = strings.TrimSpace(value[:open])
+		reference.Keys = parseKeys(value[open+len("[") : len(value)-1])
+	}
+
+	// A regular expression may contain a '/', its namespace has to precede the prefix
+	if !strings.HasPrefix(reference.Name, RegexPrefix) {
+		if slash := strings.Index(reference.Name, "/"); slash >= 0 {
+			reference.Namespace = strings.TrimSpace(reference.Name[:slash])
+			reference.Name = strings.TrimSpace(reference.Name[slash+1:])
+		}
+	}
+	return reference, true
+}
+
+// parseKeys parses the comma separated keys of a reference
+func parseKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// isResourceName checks whether the value is the name of a configmap or secret, which may be preceded by a namespace
+func isResourceName(value string) bool {
+	value = strings.TrimSpace(value)
+	if slash := strings.Index(value, "/"); slash >= 0 && namespacePattern.MatchString(strings.TrimSpace(value[:slash])) {
+		value = strings.TrimSpace(value[slash+1:])
+	}
+	return resourceNamePattern.MatchString(value)
+}
+
+// resourceNamePattern matches the names of configmaps and secrets
+var resourceNamePattern = regexp.MustCompile(`^[a-z0-9]([-.a-z0-9]*[a-z0-9])?$`)
+
+// IsPattern checks whether the reference names configmaps or secrets with a glob or a regular expression
+func (r ResourceReference) IsPattern() bool {
+	return strings.HasPrefix(r.Name, RegexPrefix) || strings.ContainsAny(r.Name, globCharacters)
+}
+
+// Matches checks whether the reference names the configmap or secret. Globs support '*', '?' and character classes, regular
+// expressions prefixed with RegexPrefix are anchored to match the whole name. An error is returned for an invalid pattern
+func (r ResourceReference) Matches(name string) (bool, error) {
+	if strings.HasPrefix(r.Name, RegexPrefix) {
+		re, err := compileAnchoredRegexp(strings.TrimPrefix(r.Name, RegexPrefix))
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(name), nil
+	}
+	if strings.ContainsAny(r.Name, globCharacters) {
+		return path.Match(r.Name, name)
+	}
+	return r.Name == name, nil
+}
+
+// WorkloadReference is a workload named in the reload targets annotation of a configmap or secret
+type WorkloadReference struct {
+	Kind string
+	Name string
+}
+
+// ParseWorkloadReferences parses the comma separated "kind/name" entries of a reload targets annotation,
+// e.g. "deployment/api,statefulset/db". Entries without a kind or a name are skipped
+func ParseWorkloadReferences(value string) []WorkloadReference {
+	references := []WorkloadReference{}
+	for _, entry := range strings.Split(value, ",") {
+		slash := strings.Index(entry, "/")
+		if slash < 0 {
+			continue
+		}
+		reference := WorkloadReference{
+			Kind: strings.TrimSpace(entry[:slash]),
+			Name: strings.TrimSpace(entry[slash+1:]),
+		}
+		if reference.Kind != "" && reference.Name != "" {
+			references = append(references, reference)
+		}
+	}
+	return references
+}
+
+// IsKind checks whether the reference names a workload of the given kind, e.g. "Deployment", ignoring the case
+func (r WorkloadReference) IsKind(kind string) bool {
+	return strings.EqualFold(r.Kind, kind)
+}
+
+func compileAnchoredRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, found := regexps.Load(pattern); found {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,25 @@
+package util
+
+import "time"
+
+// ReloadSource describes the configmap or secret which triggered the last reload of a workload
+type ReloadSource struct {
+	Type          string    `json:"type"`
+	Name          string    `json:"name"`
+	Namespace     string    `json:"namespace"`
+	Hash          string    `json:"hash"`
+	ContainerRefs []string  `json:"containerRefs"`
+	ObservedAt    time.Time `json:"observedAt"`
+}
+
+// NewReloadSourceFromConfig creates the ReloadSource of the given config
+func NewReloadSourceFromConfig(config Config, containerRefs []string) ReloadSource {
+	return ReloadSource{
+		Type:          config.Type,
+		Name:          config.ResourceName,
+		Namespace:     config.Namespace,
+		Hash:          config.SHAValue,
+		ContainerRefs: containerRefs,
+		ObservedAt:    time.Now().UTC(),
+	}
+}
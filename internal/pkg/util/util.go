@@ -2,12 +2,17 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/stakater/Reloader/internal/pkg/crypto"
+	"github.com/stakater/Reloader/internal/pkg/options"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 // ConvertToEnvVarName converts the given text into a usable env var
@@ -32,26 +37,84 @@ func ConvertToEnvVarName(text string) string {
 }
 
 func GetSHAfromConfigmap(configmap *v1.ConfigMap) string {
-	values := []string{}
+	return GetSHAfromData(GetConfigmapData(configmap))
+}
+
+func GetSHAfromSecret(secret *v1.Secret) string {
+	return GetSHAfromData(GetSecretData(secret))
+}
+
+// GetConfigmapData returns the entries of the configmap as they are hashed, binary data is base64 encoded.
+// Structured values are canonicalized if the configmap opted in to semantic hashing with its annotation
+func GetConfigmapData(configmap *v1.ConfigMap) map[string]string {
+	semanticHash, _ := strconv.ParseBool(configmap.Annotations[options.SemanticHashAnnotation])
+	data := map[string]string{}
 	for k, v := range configmap.Data {
-		values = append(values, k+"="+v)
+		if semanticHash {
+			v = CanonicalizeValue(k, v)
+		}
+		data[k] = v
 	}
 	for k, v := range configmap.BinaryData {
-		values = append(values, k+"="+base64.StdEncoding.EncodeToString(v))
+		data[k] = base64.StdEncoding.EncodeToString(v)
 	}
-	sort.Strings(values)
-	return crypto.GenerateSHA(strings.Join(values, ";"))
+	return removeIgnoredKeys(data, configmap.Annotations)
+}
+
+// GetSecretData returns the entries of the secret as they are hashed
+func GetSecretData(secret *v1.Secret) map[string]string {
+	values := map[string]string{}
+	for k, v := range secret.Data {
+		values[k] = string(v[:])
+	}
+	return removeIgnoredKeys(values, secret.Annotations)
+}
+
+// removeIgnoredKeys removes the keys matching the comma separated names and globs of the ignore keys annotation
+// from the data, so that changes of these keys never trigger reloads. Invalid globs match no key
+func removeIgnoredKeys(data map[string]string, annotations map[string]string) map[string]string {
+	value := annotations[options.IgnoreKeysAnnotation]
+	if strings.TrimSpace(value) == "" {
+		return data
+	}
+
+	patterns := strings.Split(value, ",")
+	for key := range data {
+		if MatchesAny(patterns, key) {
+			delete(data, key)
+		}
+	}
+	return data
 }
 
-func GetSHAfromSecret(data map[string][]byte) string {
+// GetSHAfromData returns the SHA of the entries of a configmap or secret
+func GetSHAfromData(data map[string]string) string {
 	values := []string{}
 	for k, v := range data {
-		values = append(values, k+"="+string(v[:]))
+		values = append(values, k+"="+v)
 	}
 	sort.Strings(values)
 	return crypto.GenerateSHA(strings.Join(values, ";"))
 }
 
+// GetSHAfromKeys returns the SHA of the given keys of the entries of a configmap or secret, keys which
+// do not exist are left out
+func GetSHAfromKeys(data map[string]string, keys []string) string {
+	selected := map[string]string{}
+	for _, key := range keys {
+		if value, found := data[key]; found {
+			selected[key] = value
+		}
+	}
+	return GetSHAfromData(selected)
+}
+
+// GetSHAfromDeletedResource returns the SHA recorded for a configmap or secret that has been deleted.
+// It can never collide with the SHA of existing data as every hashed data entry contains a '='
+func GetSHAfromDeletedResource() string {
+	return crypto.GenerateSHA("deleted")
+}
+
 type List []string
 
 func (l *List) Contains(s string) bool {
@@ -62,3 +125,18 @@ func (l *List) Contains(s string) bool {
 	}
 	return false
 }
+
+// WaitForCacheSync waits until the caches are synced, stopCh is closed or the timeout expired, e.g. as the
+// informers are forbidden to list or watch their resources and retry forever. It returns whether the caches synced
+func WaitForCacheSync(stopCh <-chan struct{}, timeout time.Duration, cacheSyncs ...cache.InformerSynced) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return cache.WaitForCacheSync(ctx.Done(), cacheSyncs...)
+}
@@ -1,9 +1,13 @@
 package util
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/stakater/Reloader/internal/pkg/options"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestConvertToEnvVarName(t *testing.T) {
@@ -45,3 +49,330 @@ func TestGetHashFromConfigMap(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSHAfromDeletedResource(t *testing.T) {
+	deletedHash := GetSHAfromDeletedResource()
+	if deletedHash == GetSHAfromConfigmap(&v1.ConfigMap{}) {
+		t.Errorf("Deleted hash collides with the hash of an empty configmap")
+	}
+	if deletedHash == GetSHAfromSecret(&v1.Secret{}) {
+		t.Errorf("Deleted hash collides with the hash of an empty secret")
+	}
+}
+
+func TestGetSHAfromKeys(t *testing.T) {
+	data := map[string]string{"log_level": "debug", "db_url": "postgres://db", "other": "value"}
+	changed := map[string]string{"log_level": "debug", "db_url": "postgres://db", "other": "changed"}
+
+	if GetSHAfromKeys(data, []string{"log_level", "db_url"}) != GetSHAfromKeys(changed, []string{"log_level", "db_url"}) {
+		t.Errorf("Hash of the selected keys changed upon a change of another key")
+	}
+	if GetSHAfromKeys(data, []string{"other"}) == GetSHAfromKeys(changed, []string{"other"}) {
+		t.Errorf("Hash of the selected keys did not change upon a change of a selected key")
+	}
+	if GetSHAfromKeys(data, []string{"log_level", "db_url", "other"}) != GetSHAfromData(data) {
+		t.Errorf("Hash of all keys differs from the hash of the data")
+	}
+}
+
+func TestParseResourceReferences(t *testing.T) {
+	references := ParseResourceReferences("app-config[log_level, db_url], other-config ,,broken[key")
+	want := []ResourceReference{
+		{Name: "app-config", Keys: []string{"log_level", "db_url"}},
+		{Name: "other-config"},
+		{Name: "broken[key"},
+	}
+	if !reflect.DeepEqual(references, want) {
+		t.Errorf("ParseResourceReferences() = %v, want %v", references, want)
+	}
+}
+
+func TestParseNamespacedResourceReferences(t *testing.T) {
+	references := ParseResourceReferences("central/app-config[log_level], central/app-config-*, re:^tenant/.*$")
+	want := []ResourceReference{
+		{Namespace: "central", Name: "app-config", Keys: []string{"log_level"}},
+		{Namespace: "central", Name: "app-config-*"},
+		{Name: "re:^tenant/.*$"},
+	}
+	if !reflect.DeepEqual(references, want) {
+		t.Errorf("ParseResourceReferences() = %v, want %v", references, want)
+	}
+}
+
+func TestParseRegexResourceReferences(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []ResourceReference
+	}{
+		{
+			name:  "TestRegexWithRepetitionShouldNotBeSplit",
+			value: `re:app-\d{1,3}, other-config`,
+			want:  []ResourceReference{{Name: `re:app-\d{1,3}`}, {Name: "other-config"}},
+		},
+		{
+			name:  "TestRegexWithCharacterClassShouldHaveNoKeys",
+			value: "re:app-[0-9],re:db-[a,b]",
+			want:  []ResourceReference{{Name: "re:app-[0-9]"}, {Name: "re:db-[a,b]"}},
+		},
+		{
+			name:  "TestRegexKeysShouldFollowSeparator",
+			value: "re:app-[0-9]+#[log_level, db_url],central/re:tenant-(a|b)#[url]",
+			want: []ResourceReference{
+				{Name: "re:app-[0-9]+", Keys: []string{"log_level", "db_url"}},
+				{Namespace: "central", Name: "re:tenant-(a|b)", Keys: []string{"url"}},
+			},
+		},
+		{
+			name:  "TestUnbalancedRegexShouldEndAtFirstComma",
+			value: "re:app-(, other-config",
+			want:  []ResourceReference{{Name: "re:app-("}, {Name: "other-config"}},
+		},
+		{
+			name:  "TestEscapedCommaShouldNotSplitRegex",
+			value: `re:app\,config,other-config[url]`,
+			want:  []ResourceReference{{Name: `re:app\,config`}, {Name: "other-config", Keys: []string{"url"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseResourceReferences(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResourceReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGlobResourceReferences(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []ResourceReference
+	}{
+		{
+			name:  "TestGlobWithCharacterClassShouldHaveNoKeys",
+			value: "app-[ab], app-[0-9]-*",
+			want:  []ResourceReference{{Name: "app-[ab]"}, {Name: "app-[0-9]-*"}},
+		},
+		{
+			name:  "TestGlobKeysShouldFollowSeparator",
+			value: "app-config-*#[log_level, db_url],central/app-[ab]#[url]",
+			want: []ResourceReference{
+				{Name: "app-config-*", Keys: []string{"log_level", "db_url"}},
+				{Namespace: "central", Name: "app-[ab]", Keys: []string{"url"}},
+			},
+		},
+		{
+			name:  "TestPlainNameKeysMayFollowSeparator",
+			value: "app-config#[url],other-config[url]",
+			want: []ResourceReference{
+				{Name: "app-config", Keys: []string{"url"}},
+				{Name: "other-config", Keys: []string{"url"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseResourceReferences(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResourceReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !(ResourceReference{Name: "app-[ab]"}).IsPattern() {
+		t.Errorf("ResourceReference.IsPattern() = false for a glob with a character class")
+	}
+}
+
+func TestParseWorkloadReferences(t *testing.T) {
+	references := ParseWorkloadReferences("deployment/api, StatefulSet/db,,rollout/, web, rollout/web")
+	want := []WorkloadReference{
+		{Kind: "deployment", Name: "api"},
+		{Kind: "StatefulSet", Name: "db"},
+		{Kind: "rollout", Name: "web"},
+	}
+	if !reflect.DeepEqual(references, want) {
+		t.Errorf("ParseWorkloadReferences() = %v, want %v", references, want)
+	}
+	if !references[1].IsKind("statefulset") {
+		t.Errorf("WorkloadReference.IsKind() should ignore the case")
+	}
+}
+
+func TestResourceReferenceMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference string
+		resource  string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "TestExactNameShouldMatch", reference: "app-config", resource: "app-config", want: true},
+		{name: "TestExactNameShouldNotMatchPrefix", reference: "app-config", resource: "app-config-7f9c2", want: false},
+		{name: "TestGlobShouldMatch", reference: "app-config-*", resource: "app-config-7f9c2", want: true},
+		{name: "TestGlobShouldNotMatch", reference: "app-config-*", resource: "other-config-7f9c2", want: false},
+		{name: "TestGlobWithCharacterClassShouldMatch", reference: "app-[ab]", resource: "app-a", want: true},
+		{name: "TestGlobWithCharacterClassShouldNotMatch", reference: "app-[ab]", resource: "app-c", want: false},
+		{name: "TestRegexShouldMatch", reference: "re:^tenant-.*-db$", resource: "tenant-a-db", want: true},
+		{name: "TestRegexShouldBeAnchored", reference: "re:tenant-.*-db", resource: "tenant-a-db-backup", want: false},
+		{name: "TestInvalidRegexShouldFail", reference: "re:tenant-(", resource: "tenant-a-db", wantErr: true},
+		{name: "TestInvalidGlobShouldFail", reference: "app-*\\", resource: "app-config", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResourceReference{Name: tt.reference}.Matches(tt.resource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResourceReference.Matches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResourceReference.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		same  string
+	}{
+		{name: "TestJSONShouldIgnoreFormattingAndOrder", key: "config.json", value: `{"b": 1, "a": [1, 2]}`, same: "{\n  \"a\": [1,2],\n  \"b\": 1\n}"},
+		{name: "TestYAMLShouldIgnoreCommentsAndOrder", key: "config.yaml", value: "b: 1\na: x\n", same: "# comment\na: x\nb: 1"},
+		{name: "TestYAMLShouldEqualJSON", key: "config.yml", value: "a: x", same: `{"a":"x"}`},
+		{name: "TestTOMLShouldIgnoreCommentsAndOrder", key: "config.toml", value: "b = 1\na = \"x\"", same: "# comment\na = \"x\"\nb = 1"},
+		{name: "TestPropertiesShouldIgnoreCommentsAndOrder", key: "app.properties", value: "b=1\na=x", same: "# comment\n! comment\na : x\n\nb = \\\n  1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if CanonicalizeValue(tt.key, tt.value) != CanonicalizeValue(tt.key, tt.same) {
+				t.Errorf("CanonicalizeValue() of '%s' and '%s' differ", tt.value, tt.same)
+			}
+		})
+	}
+
+	if got := CanonicalizeValue("config.json", `{"a": 1`); got != `{"a": 1` {
+		t.Errorf("CanonicalizeValue() of an invalid value = '%s', want the raw value", got)
+	}
+	if got := CanonicalizeValue("config.txt", ` {"a": 1} `); got != ` {"a": 1} ` {
+		t.Errorf("CanonicalizeValue() of an unknown format = '%s', want the raw value", got)
+	}
+	if CanonicalizeValue("config.json", `{"a": 1}`) == CanonicalizeValue("config.json", `{"a": 2}`) {
+		t.Errorf("CanonicalizeValue() of different values should differ")
+	}
+
+	// Changes after the first JSON value or YAML document must change the canonical form
+	differing := []struct {
+		key    string
+		value  string
+		change string
+	}{
+		{key: "config.json", value: `{"a":1} {"b":2}`, change: `{"a":1} {"b":3}`},
+		{key: "config.yaml", value: "a: 1\n---\nb: 2", change: "a: 1\n---\nb: 3"},
+		{key: "config.yaml", value: "a: 1\n...\nb: 2", change: "a: 1\n...\nb: 3"},
+	}
+	for _, tt := range differing {
+		if CanonicalizeValue(tt.key, tt.value) == CanonicalizeValue(tt.key, tt.change) {
+			t.Errorf("CanonicalizeValue() of '%s' and '%s' should differ", tt.value, tt.change)
+		}
+	}
+	if CanonicalizeValue("config.yaml", "b: 2\na: 1\n---\nc: 3") != CanonicalizeValue("config.yaml", "a: 1\nb: 2\n---\n# comment\nc: 3\n") {
+		t.Errorf("CanonicalizeValue() should canonicalize every YAML document")
+	}
+}
+
+func TestGetConfigmapDataWithSemanticHash(t *testing.T) {
+	configmap := &v1.ConfigMap{
+		Data: map[string]string{"config.json": `{"b": 1, "a": 2}`},
+	}
+	reformatted := &v1.ConfigMap{
+		Data: map[string]string{"config.json": "{\n  \"a\": 2,\n  \"b\": 1\n}"},
+	}
+	if GetSHAfromConfigmap(configmap) == GetSHAfromConfigmap(reformatted) {
+		t.Errorf("Reformatting should change the SHA without the semantic hash annotation")
+	}
+
+	configmap.Annotations = map[string]string{options.SemanticHashAnnotation: "true"}
+	reformatted.Annotations = map[string]string{options.SemanticHashAnnotation: "true"}
+	if GetSHAfromConfigmap(configmap) != GetSHAfromConfigmap(reformatted) {
+		t.Errorf("Reformatting should not change the SHA with the semantic hash annotation")
+	}
+}
+
+func TestGetSHAWithIgnoredKeys(t *testing.T) {
+	annotations := map[string]string{options.IgnoreKeysAnnotation: "last-updated, checksum-*, [invalid"}
+	configmap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Data:       map[string]string{"url": "www.stakater.com", "last-updated": "1", "checksum-a": "x"},
+	}
+	rewritten := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Data:       map[string]string{"url": "www.stakater.com", "last-updated": "2", "checksum-a": "y"},
+	}
+	if GetSHAfromConfigmap(configmap) != GetSHAfromConfigmap(rewritten) {
+		t.Errorf("Changes of ignored keys should not change the SHA of the configmap")
+	}
+	rewritten.Data["url"] = "www.stakater.com/changed"
+	if GetSHAfromConfigmap(configmap) == GetSHAfromConfigmap(rewritten) {
+		t.Errorf("Changes of other keys should change the SHA of the configmap")
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Data:       map[string][]byte{"password": []byte("secret"), "last-updated": []byte("1")},
+	}
+	if _, found := GetSecretData(secret)["last-updated"]; found {
+		t.Errorf("Ignored keys should be removed from the data of the secret")
+	}
+}
+
+func TestGetDataManagers(t *testing.T) {
+	oldest := metav1.NewTime(metav1.Now().Add(-2 * time.Hour))
+	older := metav1.NewTime(metav1.Now().Add(-time.Hour))
+	newer := metav1.Now()
+	managedFields := []metav1.ManagedFieldsEntry{
+		{Manager: "kubectl-create", Time: &oldest, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:url":{}}}`)}},
+		{Manager: "argocd-controller", Time: &older, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:version":{}}}`)}},
+		{Manager: "kubectl-edit", Time: &newer, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{}}}`)}},
+	}
+
+	keys := GetChangedKeys(map[string]string{"url": "a", "version": "1"}, map[string]string{"url": "a", "version": "2"})
+	if managers := GetDataManagers(managedFields, keys); !reflect.DeepEqual(managers, []string{"argocd-controller"}) {
+		t.Errorf("Unexpected managers %v of the changed keys %v", managers, keys)
+	}
+	if managers := GetDataManagers(managedFields, []string{"url", "version"}); !reflect.DeepEqual(managers, []string{"argocd-controller", "kubectl-create"}) {
+		t.Errorf("Unexpected managers %v of several keys", managers)
+	}
+	// Removed keys have no manager anymore, the latest manager made the change even if it owns no entries
+	if managers := GetDataManagers(managedFields, []string{"removed"}); !reflect.DeepEqual(managers, []string{"kubectl-edit"}) {
+		t.Errorf("Unexpected managers %v of removed keys", managers)
+	}
+	if managers := GetDataManagers(nil, keys); len(managers) != 0 {
+		t.Errorf("Unexpected managers %v without managed fields", managers)
+	}
+}
+
+func TestAnyManagerAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		managers []string
+		allowed  string
+		ignored  string
+		want     bool
+	}{
+		{name: "TestAllManagersShouldBeAllowedByDefault", managers: []string{"kubectl-edit"}, want: true},
+		{name: "TestEmptyAllowListShouldAllowAllManagers", managers: []string{"kubectl-edit"}, allowed: " , ", want: true},
+		{name: "TestAllowedManagerShouldBeAllowed", managers: []string{"argocd-controller"}, allowed: "argocd-controller, external-secrets", want: true},
+		{name: "TestOtherManagerShouldNotBeAllowed", managers: []string{"kubectl-edit"}, allowed: "argocd-controller", want: false},
+		{name: "TestIgnoredManagerShouldNotBeAllowed", managers: []string{"kubectl-edit"}, ignored: "kubectl-*", want: false},
+		{name: "TestIgnoredManagerShouldWinOverAllowedManager", managers: []string{"kubectl-edit"}, allowed: "kubectl-edit", ignored: "kubectl-edit", want: false},
+		{name: "TestAnyAllowedManagerShouldBeEnough", managers: []string{"argocd-controller", "kubectl-edit"}, ignored: "kubectl-edit", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AnyManagerAllowed(tt.managers, ParseManagers(tt.allowed), ParseManagers(tt.ignored)); got != tt.want {
+				t.Errorf("AnyManagerAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
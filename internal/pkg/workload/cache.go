@@ -0,0 +1,256 @@
+package workload
+
+import (
+	"strings"
+	"time"
+
+	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	argorolloutinformers "github.com/argoproj/argo-rollouts/pkg/client/informers/externalversions/rollouts/v1alpha1"
+	openshiftv1 "github.com/openshift/api/apps/v1"
+	openshiftinformers "github.com/openshift/client-go/apps/informers/externalversions/apps/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// referencesIndex is the name of the index from configmaps and secrets to the workloads referencing them
+const referencesIndex = "references"
+
+// anyName is the name under which workloads referencing configmaps or secrets by a pattern are indexed,
+// it is never the name of a configmap or secret
+const anyName = "*"
+
+// Cache keeps the workloads reloaded by Reloader in informers, indexed by the configmaps and secrets they reference
+type Cache struct {
+	// informers holds the informers of every watched namespace by resource type
+	informers map[string][]cache.SharedIndexInformer
+}
+
+// sharedCache is the cache used to resolve the workloads of configmap and secret events, if any
+var sharedCache *Cache
+
+// SetSharedCache sets the cache used to resolve the workloads of configmap and secret events
+func SetSharedCache(c *Cache) {
+	sharedCache = c
+}
+
+// GetSharedCache returns the cache used to resolve the workloads of configmap and secret events,
+// or nil if workloads have to be listed from the API server
+func GetSharedCache() *Cache {
+	return sharedCache
+}
+
+// NewCache creates informers for every workload type supported in each of the given namespaces
+func NewCache(clients kube.Clients, namespaces []string) *Cache {
+	indexers := cache.Indexers{referencesIndex: indexByReferences}
+	c := &Cache{
+		informers: map[string][]cache.SharedIndexInformer{},
+	}
+
+	for _, namespace := range namespaces {
+		c.addInformer("Deployment", appsinformers.NewDeploymentInformer(clients.KubernetesClient, namespace, 0, indexers))
+		c.addInformer("DaemonSet", appsinformers.NewDaemonSetInformer(clients.KubernetesClient, namespace, 0, indexers))
+		c.addInformer("StatefulSet", appsinformers.NewStatefulSetInformer(clients.KubernetesClient, namespace, 0, indexers))
+
+		if kube.IsOpenshift && clients.OpenshiftAppsClient != nil {
+			c.addInformer("DeploymentConfig", openshiftinformers.NewDeploymentConfigInformer(clients.OpenshiftAppsClient, namespace, 0, indexers))
+		}
+
+		if options.IsArgoRollouts == "true" && clients.ArgoRolloutClient != nil {
+			c.addInformer("Rollout", argorolloutinformers.NewRolloutInformer(clients.ArgoRolloutClient, namespace, 0, indexers))
+		}
+	}
+
+	return c
+}
+
+func (c *Cache) addInformer(resourceType string, informer cache.SharedIndexInformer) {
+	c.informers[resourceType] = append(c.informers[resourceType], informer)
+}
+
+// Run starts the informers and blocks until they are synced or the timeout expired. It returns whether they synced
+func (c *Cache) Run(stopCh <-chan struct{}, timeout time.Duration) bool {
+	hasSynced := []cache.InformerSynced{}
+	for resourceType, informers := range c.informers {
+		logrus.Infof("Starting %d informer(s) to cache resource type: %s", len(informers), resourceType)
+		for _, informer := range informers {
+			go informer.Run(stopCh)
+			hasSynced = append(hasSynced, informer.HasSynced)
+		}
+	}
+	return util.WaitForCacheSync(stopCh, timeout, hasSynced...)
+}
+
+// ItemsReferencing returns copies of the workloads of given resource type referencing the configmap or secret of
+// given config. It returns false if the resource type is not cached
+func (c *Cache) ItemsReferencing(resourceType string, config util.Config) ([]interface{}, bool) {
+	informers, ok := c.informers[resourceType]
+	if !ok {
+		return nil, false
+	}
+
+	items := []interface{}{}
+	for _, informer := range informers {
+		found := map[interface{}]bool{}
+		for _, name := range []string{config.ResourceName, anyName} {
+			objects, err := informer.GetIndexer().ByIndex(referencesIndex, referenceKey(config.Type, config.Namespace, name))
+			if err != nil {
+				logrus.Errorf("Failed to get %s items from cache: %v", resourceType, err)
+				return nil, false
+			}
+
+			for _, object := range objects {
+				if found[object] {
+					continue
+				}
+				found[object] = true
+				// objects in the cache are shared and must never be modified
+				items = append(items, object.(runtime.Object).DeepCopyObject())
+			}
+		}
+	}
+	return items, true
+}
+
+// Item returns a copy of the workload of given resource type, namespace and name. It returns false if the workload
+// is not cached
+func (c *Cache) Item(resourceType string, namespace string, name string) (interface{}, bool) {
+	for _, informer := range c.informers[resourceType] {
+		object, exists, err := informer.GetIndexer().GetByKey(namespace + "/" + name)
+		if err != nil {
+			logrus.Errorf("Failed to get %s '%s' in namespace '%s' from cache: %v", resourceType, name, namespace, err)
+			continue
+		}
+		if exists {
+			// objects in the cache are shared and must never be modified
+			return object.(runtime.Object).DeepCopyObject(), true
+		}
+	}
+	return nil, false
+}
+
+func referenceKey(resourceType string, namespace string, name string) string {
+	return strings.ToLower(resourceType) + "/" + namespace + "/" + name
+}
+
+// indexByReferences returns a key for every configmap and secret used in the pod template of the workload
+// or named in its reload annotations
+func indexByReferences(obj interface{}) ([]string, error) {
+	var meta, templateMeta *metav1.ObjectMeta
+	var podSpec *v1.PodSpec
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		meta, templateMeta, podSpec = &workload.ObjectMeta, &workload.Spec.Template.ObjectMeta, &workload.Spec.Template.Spec
+	case *appsv1.DaemonSet:
+		meta, templateMeta, podSpec = &workload.ObjectMeta, &workload.Spec.Template.ObjectMeta, &workload.Spec.Template.Spec
+	case *appsv1.StatefulSet:
+		meta, templateMeta, podSpec = &workload.ObjectMeta, &workload.Spec.Template.ObjectMeta, &workload.Spec.Template.Spec
+	case *openshiftv1.DeploymentConfig:
+		meta = &workload.ObjectMeta
+		if workload.Spec.Template != nil {
+			templateMeta, podSpec = &workload.Spec.Template.ObjectMeta, &workload.Spec.Template.Spec
+		}
+	case *argorolloutv1alpha1.Rollout:
+		meta, templateMeta, podSpec = &workload.ObjectMeta, &workload.Spec.Template.ObjectMeta, &workload.Spec.Template.Spec
+	default:
+		return nil, nil
+	}
+
+	references := map[string]bool{}
+	addNamespacedReference := func(resourceType string, namespace string, name string) {
+		if name != "" {
+			references[referenceKey(resourceType, namespace, name)] = true
+		}
+	}
+	addReference := func(resourceType string, name string) {
+		addNamespacedReference(resourceType, meta.Namespace, name)
+	}
+	// Patterns may match any configmap or secret, so the workload is indexed for all of them
+	addAnnotationReference := func(resourceType string, reference util.ResourceReference) {
+		namespace := meta.Namespace
+		if reference.Namespace != "" {
+			namespace = reference.Namespace
+		}
+		if reference.IsPattern() {
+			addNamespacedReference(resourceType, namespace, anyName)
+		} else {
+			addNamespacedReference(resourceType, namespace, reference.Name)
+		}
+	}
+
+	for _, objectMeta := range []*metav1.ObjectMeta{meta, templateMeta} {
+		if objectMeta == nil {
+			continue
+		}
+		for _, reference := range util.ParseResourceReferences(objectMeta.Annotations[options.ConfigmapUpdateOnChangeAnnotation]) {
+			addAnnotationReference(constants.ConfigmapEnvVarPostfix, reference)
+		}
+		for _, reference := range util.ParseResourceReferences(objectMeta.Annotations[options.SecretUpdateOnChangeAnnotation]) {
+			addAnnotationReference(constants.SecretEnvVarPostfix, reference)
+		}
+		// Label selectors may match any configmap or secret as well
+		if strings.TrimSpace(objectMeta.Annotations[options.ConfigmapReloadSelectorAnnotation]) != "" {
+			addReference(constants.ConfigmapEnvVarPostfix, anyName)
+		}
+		if strings.TrimSpace(objectMeta.Annotations[options.SecretReloadSelectorAnnotation]) != "" {
+			addReference(constants.SecretEnvVarPostfix, anyName)
+		}
+	}
+
+	if podSpec != nil {
+		for _, volume := range podSpec.Volumes {
+			if volume.ConfigMap != nil {
+				addReference(constants.ConfigmapEnvVarPostfix, volume.ConfigMap.Name)
+			}
+			if volume.Secret != nil {
+				addReference(constants.SecretEnvVarPostfix, volume.Secret.SecretName)
+			}
+			if volume.Projected != nil {
+				for _, source := range volume.Projected.Sources {
+					if source.ConfigMap != nil {
+						addReference(constants.ConfigmapEnvVarPostfix, source.ConfigMap.Name)
+					}
+					if source.Secret != nil {
+						addReference(constants.SecretEnvVarPostfix, source.Secret.Name)
+					}
+				}
+			}
+		}
+
+		containers := make([]v1.Container, 0, len(podSpec.Containers)+len(podSpec.InitContainers))
+		containers = append(containers, podSpec.Containers...)
+		containers = append(containers, podSpec.InitContainers...)
+		for _, container := range containers {
+			for _, env := range container.Env {
+				if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+					addReference(constants.ConfigmapEnvVarPostfix, env.ValueFrom.ConfigMapKeyRef.Name)
+				}
+				if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+					addReference(constants.SecretEnvVarPostfix, env.ValueFrom.SecretKeyRef.Name)
+				}
+			}
+			for _, envFrom := range container.EnvFrom {
+				if envFrom.ConfigMapRef != nil {
+					addReference(constants.ConfigmapEnvVarPostfix, envFrom.ConfigMapRef.Name)
+				}
+				if envFrom.SecretRef != nil {
+					addReference(constants.SecretEnvVarPostfix, envFrom.SecretRef.Name)
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(references))
+	for key := range references {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
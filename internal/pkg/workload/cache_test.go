@@ -0,0 +1,217 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/testutil"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestIndexByReferences(t *testing.T) {
+	deployment := testutil.GetDeployment("test", "app")
+	deployment.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: "first, second[log_level,db_url]",
+	}
+
+	keys, err := indexByReferences(deployment)
+	if err != nil {
+		t.Fatalf("indexByReferences() failed: %v", err)
+	}
+	sort.Strings(keys)
+	want := []string{
+		"configmap/test/app",
+		"configmap/test/first",
+		"configmap/test/second",
+		"secret/test/app",
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("indexByReferences() = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("indexByReferences() = %v, want %v", keys, want)
+		}
+	}
+}
+
+func TestItemsReferencing(t *testing.T) {
+	client := testclient.NewSimpleClientset(
+		testutil.GetDeployment("test", "app"),
+		testutil.GetDeploymentWithEnvVarSources("test", "other"),
+		testutil.GetDeploymentWithEnvVarSources("another-namespace", "app"),
+	)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{""})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	config := util.Config{Namespace: "test", ResourceName: "app", Type: constants.ConfigmapEnvVarPostfix}
+	items, ok := workloadCache.ItemsReferencing("Deployment", config)
+	if !ok {
+		t.Fatalf("Deployments are not cached")
+	}
+	if len(items) != 1 {
+		t.Fatalf("ItemsReferencing() returned %d items, want 1", len(items))
+	}
+	deployment := items[0].(*appsv1.Deployment)
+	if deployment.Namespace != "test" || deployment.Name != "app" {
+		t.Errorf("ItemsReferencing() returned %s/%s, want test/app", deployment.Namespace, deployment.Name)
+	}
+
+	if _, ok := workloadCache.ItemsReferencing("Rollout", config); ok {
+		t.Errorf("Rollouts are cached unexpectedly")
+	}
+}
+
+func TestRunTimesOutWithoutPermissionToWatch(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	client.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(appsv1.Resource("deployments"), "", fmt.Errorf("forbidden"))
+	})
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{""})
+	stop := make(chan struct{})
+	defer close(stop)
+	if workloadCache.Run(stop, 100*time.Millisecond) {
+		t.Errorf("Run() synced without permission to list deployments")
+	}
+}
+
+func TestItemsReferencingInWatchedNamespaces(t *testing.T) {
+	client := testclient.NewSimpleClientset(
+		testutil.GetDeployment("test", "app"),
+		testutil.GetDeployment("another-namespace", "app"),
+		testutil.GetDeployment("unwatched-namespace", "app"),
+	)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{"test", "another-namespace"})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	for _, namespace := range []string{"test", "another-namespace"} {
+		config := util.Config{Namespace: namespace, ResourceName: "app", Type: constants.ConfigmapEnvVarPostfix}
+		items, _ := workloadCache.ItemsReferencing("Deployment", config)
+		if len(items) != 1 {
+			t.Errorf("ItemsReferencing() returned %d items in namespace %s, want 1", len(items), namespace)
+		}
+	}
+
+	config := util.Config{Namespace: "unwatched-namespace", ResourceName: "app", Type: constants.ConfigmapEnvVarPostfix}
+	if items, _ := workloadCache.ItemsReferencing("Deployment", config); len(items) != 0 {
+		t.Errorf("ItemsReferencing() returned %d items in an unwatched namespace, want 0", len(items))
+	}
+}
+
+func TestItemsReferencingByPattern(t *testing.T) {
+	deployment := testutil.GetDeployment("test", "app")
+	deployment.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: "app-config-*",
+	}
+	client := testclient.NewSimpleClientset(deployment)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{""})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	for _, name := range []string{"app-config-7f9c2", "app"} {
+		config := util.Config{Namespace: "test", ResourceName: name, Type: constants.ConfigmapEnvVarPostfix}
+		items, _ := workloadCache.ItemsReferencing("Deployment", config)
+		if len(items) != 1 {
+			t.Errorf("ItemsReferencing() returned %d items for configmap %s, want 1", len(items), name)
+		}
+	}
+}
+
+func TestItemsReferencingInOtherNamespaces(t *testing.T) {
+	deployment := testutil.GetDeployment("test", "app")
+	deployment.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: "central/shared-config, central/shared-*",
+	}
+	client := testclient.NewSimpleClientset(deployment)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{""})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	for _, name := range []string{"shared-config", "shared-ca"} {
+		config := util.Config{Namespace: "central", ResourceName: name, Type: constants.ConfigmapEnvVarPostfix}
+		items, _ := workloadCache.ItemsReferencing("Deployment", config)
+		if len(items) != 1 {
+			t.Errorf("ItemsReferencing() returned %d items for configmap central/%s, want 1", len(items), name)
+		}
+	}
+
+	config := util.Config{Namespace: "test", ResourceName: "shared-config", Type: constants.ConfigmapEnvVarPostfix}
+	if items, _ := workloadCache.ItemsReferencing("Deployment", config); len(items) != 0 {
+		t.Errorf("ItemsReferencing() returned %d items for configmap test/shared-config, want 0", len(items))
+	}
+}
+
+func TestItemsReferencingBySelector(t *testing.T) {
+	deployment := testutil.GetDeployment("test", "app")
+	deployment.Annotations = map[string]string{
+		options.ConfigmapReloadSelectorAnnotation: "app=payments,tier=config",
+	}
+	client := testclient.NewSimpleClientset(deployment)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{""})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	config := util.Config{Namespace: "test", ResourceName: "payments-config", Type: constants.ConfigmapEnvVarPostfix}
+	if items, _ := workloadCache.ItemsReferencing("Deployment", config); len(items) != 1 {
+		t.Errorf("ItemsReferencing() returned %d items for configmap payments-config, want 1", len(items))
+	}
+
+	config = util.Config{Namespace: "test", ResourceName: "payments-secret", Type: constants.SecretEnvVarPostfix}
+	if items, _ := workloadCache.ItemsReferencing("Deployment", config); len(items) != 0 {
+		t.Errorf("ItemsReferencing() returned %d items for secret payments-secret, want 0", len(items))
+	}
+}
+
+func TestItem(t *testing.T) {
+	client := testclient.NewSimpleClientset(
+		testutil.GetDeployment("test", "app"),
+		testutil.GetDeployment("another-namespace", "other"),
+	)
+	workloadCache := NewCache(kube.Clients{KubernetesClient: client}, []string{"test", "another-namespace"})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !workloadCache.Run(stop, time.Minute) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	item, found := workloadCache.Item("Deployment", "another-namespace", "other")
+	if !found {
+		t.Fatalf("Item() did not find deployment another-namespace/other")
+	}
+	if deployment := item.(*appsv1.Deployment); deployment.Namespace != "another-namespace" || deployment.Name != "other" {
+		t.Errorf("Item() returned %s/%s, want another-namespace/other", deployment.Namespace, deployment.Name)
+	}
+	if _, found := workloadCache.Item("Deployment", "test", "other"); found {
+		t.Errorf("Item() found a deployment in the wrong namespace")
+	}
+	if _, found := workloadCache.Item("Rollout", "test", "app"); found {
+		t.Errorf("Item() found an uncached resource type")
+	}
+}